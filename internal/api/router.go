@@ -2,13 +2,14 @@ package api
 
 import (
 	"database/sql"
+	"tailscale-go-proxy/internal/pac"
 	"tailscale-go-proxy/internal/register"
 
 	"github.com/gin-gonic/gin"
 )
 
-// NewRouter 创建 gin 路由
-func NewRouter(db *sql.DB) *gin.Engine {
+// NewRouter 创建 gin 路由，pacGen 为 nil 时不提供 PAC 接口
+func NewRouter(db *sql.DB, pacGen *pac.Generator) *gin.Engine {
 	r := gin.Default()
 	r.POST("/register", func(c *gin.Context) {
 		register.HandleRegister(c, db)
@@ -17,5 +18,9 @@ func NewRouter(db *sql.DB) *gin.Engine {
 	r.GET("/registerV2/:key", func(c *gin.Context) {
 		register.HandleRegisterV2(c, db)
 	})
+	// 按注册 key 生成浏览器 PAC 文件，支持 ?proxy=socks5|http
+	if pacGen != nil {
+		r.GET("/pac/:key", pacGen.Handle)
+	}
 	return r
 }
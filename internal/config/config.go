@@ -3,6 +3,7 @@ package config
 import (
 	"database/sql"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 	"gopkg.in/yaml.v3"
@@ -17,6 +18,159 @@ type Config struct {
 	DBName        string `yaml:"db_name"`
 	TSAuthKey     string `yaml:"ts_authkey"`
 	LoginServer   string `yaml:"login_server"`
+	// Tailscale 为接入 tailnet 的方式，未配置时启动 tailscaled
+	Tailscale TailscaleConfig `yaml:"tailscale"`
+
+	// 代理监听端口：0 使用默认端口，-1 关闭该监听
+	SOCKS5ProxyPort int `yaml:"socks5_proxy_port"`
+	HTTPProxyPort   int `yaml:"http_proxy_port"`
+	// MixedProxyPort 为单端口混合协议（SOCKS4/SOCKS5/HTTP）监听端口，0 表示不启用
+	MixedProxyPort int `yaml:"mixed_proxy_port"`
+	// HTTPSProxyPort 为 TLS 加密的 HTTP 代理（https:// 代理）监听端口，0 表示不启用
+	HTTPSProxyPort int `yaml:"https_proxy_port"`
+	// TLS 证书与私钥文件，证书更新后自动重新加载；TLSClientCAFile 非空时校验客户端证书
+	TLSCertFile     string `yaml:"tls_cert_file"`
+	TLSKeyFile      string `yaml:"tls_key_file"`
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+	// ShutdownTimeout 为收到退出信号后排空代理隧道的期限（如 "30s"），超时后强制关闭
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// 代理会话超时（如 "30s"、"30m"）：0 使用默认值，负值表示不限制
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout"` // 客户端完成握手与认证的期限
+	DialTimeout      time.Duration `yaml:"dial_timeout"`      // 连接下游节点的超时
+	IdleTimeout      time.Duration `yaml:"idle_timeout"`      // 隧道双向无数据的最长时间
+	// StickyTTL 为用户名携带 session 参数时固定经同一节点的时长，0 使用默认值（10m）
+	StickyTTL time.Duration `yaml:"sticky_ttl"`
+
+	// ProxyProtocolTrusted 为允许发送 PROXY protocol v1/v2 头部的来源网段（如前置 nginx/HAProxy），为空表示不解析
+	ProxyProtocolTrusted []string `yaml:"proxy_protocol_trusted"`
+	// ProxyProtocolDownstream 为向下游节点发送的 PROXY 头部版本（1 或 2），0 表示不发送
+	ProxyProtocolDownstream int `yaml:"proxy_protocol_downstream"`
+
+	// PAC 为管理 API /pac/:key 生成 PAC 文件的参数
+	PAC PACConfig `yaml:"pac"`
+
+	// Anonymous 为未提供认证信息的客户端的路由策略，未配置时拒绝匿名访问
+	Anonymous AnonymousConfig `yaml:"anonymous"`
+
+	// Routes 按注册 key 指定结构化路由，优先于数据库中的注册记录
+	Routes map[string]RouteConfig `yaml:"routes"`
+
+	// Rules 为全局路由规则，按目标地址选择直连、命名路由或拒绝，在各 key 的规则之后匹配
+	Rules []RuleConfig `yaml:"rules"`
+	// NamedRoutes 为规则中 action: route 可引用的命名路由
+	NamedRoutes map[string]RouteConfig `yaml:"named_routes"`
+	// GeoIPDB 为 geoip 规则使用的 MaxMind 国家数据库（mmdb）路径，为空时不能使用 geoip 规则
+	GeoIPDB string `yaml:"geoip_db"`
+
+	// DNS 为目标域名的解析方式、缓存与 hosts 覆盖，未配置时域名交给下游节点解析
+	DNS DNSConfig `yaml:"dns"`
+	// DualStack 为连接注册节点与直连目标时的地址族偏好，未配置时优先 IPv4 并按 RFC 8305 竞速
+	DualStack DualStackConfig `yaml:"dual_stack"`
+}
+
+// Tailscale 接入方式
+const (
+	TailscaleKernel = "kernel" // 启动 tailscaled 与 tailscale up，需要 NET_ADMIN 与 /dev/net/tun（默认）
+	TailscaleTsnet  = "tsnet"  // 以 tsnet 在进程内加入 tailnet，经用户态网络栈连接节点，需以 -tags tsnet 构建
+)
+
+// TailscaleConfig 接入 tailnet 的参数，hostname、state_dir、ephemeral 仅用于 tsnet 模式。
+// 同一主机运行多个 tsnet 实例时，各实例须使用不同的 state_dir 与 hostname。
+type TailscaleConfig struct {
+	Mode      string `yaml:"mode"`      // kernel（默认）或 tsnet
+	Hostname  string `yaml:"hostname"`  // 节点主机名，默认 go-proxy
+	StateDir  string `yaml:"state_dir"` // 节点状态目录，默认 /var/lib/tailscale-go-proxy/tsnet
+	Ephemeral bool   `yaml:"ephemeral"` // 注册为临时节点，离线后自动删除
+}
+
+// PACConfig PAC 文件参数
+type PACConfig struct {
+	ProxyHost     string   `yaml:"proxy_host"`     // 浏览器访问代理使用的主机名，为空时取请求的 Host
+	DefaultProxy  string   `yaml:"default_proxy"`  // 默认代理类型：http（默认）或 socks5
+	BypassDomains []string `yaml:"bypass_domains"` // 直连域名，同时匹配子域名
+	BypassCIDRs   []string `yaml:"bypass_cidrs"`   // 直连网段
+}
+
+// AnonymousConfig 匿名访问策略：按来源网段匹配 routes，均未命中时使用 default_route（为空则拒绝）。
+// 路由可以是注册 key，也可以是下游代理地址。
+type AnonymousConfig struct {
+	DefaultRoute string                 `yaml:"default_route"`
+	Routes       []AnonymousRouteConfig `yaml:"routes"`
+}
+
+// AnonymousRouteConfig 为单条按来源网段的匿名路由。
+type AnonymousRouteConfig struct {
+	CIDR  string `yaml:"cidr"`
+	Route string `yaml:"route"`
+}
+
+// RouteConfig 结构化路由：direct、hops、failover、pool 只能设置其一，字段含义见 gost.Route。
+type RouteConfig struct {
+	Direct   bool              `yaml:"direct"`
+	Node     bool              `yaml:"node"` // hops 为单个注册节点，UDP 经同一端口的 SOCKS5 转发
+	Hops     []HopConfig       `yaml:"hops"`
+	Failover []RouteConfig     `yaml:"failover"`
+	Pool     []RouteConfig     `yaml:"pool"`
+	Strategy string            `yaml:"strategy"` // 节点池策略：round_robin（默认）、random、least_active、consistent_hash
+	Labels   map[string]string `yaml:"labels"`   // 作为节点池成员时的节点标签
+	Timeout  time.Duration     `yaml:"timeout"`  // 经该路由建连的总超时
+	Cooldown time.Duration     `yaml:"cooldown"` // failover 候选失败后的冷却时长
+}
+
+// HopConfig 路由中的一层下游代理，字段含义见 gost.Hop。
+type HopConfig struct {
+	Type     string        `yaml:"type"` // http、https、socks5、ss、ssh
+	Addr     string        `yaml:"addr"` // host:port
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Cipher   string        `yaml:"cipher"`  // 仅 ss 节点
+	Timeout  time.Duration `yaml:"timeout"` // 与该层完成握手的超时
+	TLS      *HopTLSConfig `yaml:"tls"`     // 仅 https 节点
+	SSH      *HopSSHConfig `yaml:"ssh"`     // 仅 ssh 节点
+}
+
+// HopTLSConfig https 节点的 TLS 参数。
+type HopTLSConfig struct {
+	ServerName         string `yaml:"server_name"`
+	CAFile             string `yaml:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// HopSSHConfig ssh 节点的认证与主机密钥参数。
+type HopSSHConfig struct {
+	KeyFile               string `yaml:"key_file"`
+	PasswordFile          string `yaml:"password_file"`
+	KnownHostsFile        string `yaml:"known_hosts_file"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+}
+
+// RuleConfig 按目标地址选择路由的规则，字段含义见 gost.Rule。
+type RuleConfig struct {
+	Domain      []string `yaml:"domain"`
+	DomainRegex []string `yaml:"domain_regex"`
+	CIDR        []string `yaml:"cidr"`
+	Port        []string `yaml:"port"`
+	GeoIP       []string `yaml:"geoip"`
+	Action      string   `yaml:"action"` // direct、route 或 reject
+	Route       string   `yaml:"route"`  // action 为 route 时的命名路由
+}
+
+// DNSConfig 目标域名的解析方式，字段含义见 gost.DNSConfig。
+type DNSConfig struct {
+	Mode      string                       `yaml:"mode"`       // remote（默认）、local、doh 或 tailnet
+	Servers   []string                     `yaml:"servers"`    // local 与 tailnet 使用的 DNS 服务器
+	DoHURL    string                       `yaml:"doh_url"`    // doh 使用的地址
+	CacheTTL  time.Duration                `yaml:"cache_ttl"`  // 解析结果缓存时长上限，按记录的最小 TTL 缓存且不超过该值
+	Hosts     map[string]string            `yaml:"hosts"`      // 全局 hosts 覆盖
+	UserHosts map[string]map[string]string `yaml:"user_hosts"` // 按注册 key 的 hosts 覆盖
+}
+
+// DualStackConfig 地址族偏好，字段含义见 gost.DualStackConfig。
+type DualStackConfig struct {
+	Prefer        string            `yaml:"prefer"`         // ipv4（默认）或 ipv6
+	UserPrefer    map[string]string `yaml:"user_prefer"`    // 按注册 key 的地址族偏好
+	FallbackDelay time.Duration     `yaml:"fallback_delay"` // 连接尝试间隔
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -33,7 +187,9 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
-// InitPGTable 检查并自动创建 register_key_ip_map 表
+// InitPGTable 检查并自动创建 register_key_ip_map 表，ip6_address 列保存节点的 IPv6 地址（可为空），route 列保存 JSON 格式的结构化路由（可为空）；
+// register_key_pool 与 register_key_pool_member 保存按 key 分组的节点池及其负载均衡策略，labels 列保存节点标签；
+// register_key_rules 保存各 key 的路由规则（JSON 数组）
 func InitPGTable(db *sql.DB) error {
 	createTableSQL := `CREATE TABLE IF NOT EXISTS register_key_ip_map (
 		id SERIAL PRIMARY KEY,
@@ -41,6 +197,41 @@ func InitPGTable(db *sql.DB) error {
 		ip_address VARCHAR(64) NOT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	)`
-	_, err := db.Exec(createTableSQL)
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE register_key_ip_map ADD COLUMN IF NOT EXISTS route JSONB`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE register_key_ip_map ADD COLUMN IF NOT EXISTS ip6_address VARCHAR(64)`); err != nil {
+		return err
+	}
+	createPoolSQL := `CREATE TABLE IF NOT EXISTS register_key_pool (
+		reg_key VARCHAR(255) PRIMARY KEY,
+		strategy VARCHAR(32) NOT NULL DEFAULT 'round_robin',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := db.Exec(createPoolSQL); err != nil {
+		return err
+	}
+	createPoolMemberSQL := `CREATE TABLE IF NOT EXISTS register_key_pool_member (
+		id SERIAL PRIMARY KEY,
+		reg_key VARCHAR(255) NOT NULL REFERENCES register_key_pool(reg_key) ON DELETE CASCADE,
+		ip_address VARCHAR(64) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (reg_key, ip_address)
+	)`
+	if _, err := db.Exec(createPoolMemberSQL); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`ALTER TABLE register_key_pool_member ADD COLUMN IF NOT EXISTS labels JSONB`); err != nil {
+		return err
+	}
+	createRulesSQL := `CREATE TABLE IF NOT EXISTS register_key_rules (
+		reg_key VARCHAR(255) PRIMARY KEY,
+		rules JSONB NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(createRulesSQL)
 	return err
 }
@@ -0,0 +1,97 @@
+package gost
+
+import (
+	"fmt"
+	"net"
+)
+
+// AnonymousRule 为按来源地址选择匿名路由的规则。
+type AnonymousRule struct {
+	CIDR  string // 来源网段，如 "10.0.0.0/8"
+	Route string // 路由：注册 key 或下游代理地址
+}
+
+// AnonymousPolicy 决定未提供认证信息的客户端（HTTP 无 Proxy-Authorization、SOCKS5 无认证方法、
+// SOCKS4 空 USERID）使用的路由。nil 或零值策略拒绝所有匿名访问。
+//
+// 路由既可以是注册 key（按 key:key 在 UserProxyMap 中查找，随数据库重新加载生效），
+// 也可以是 ParseRoute 支持的路由（下游代理地址、代理链或 direct）。
+type AnonymousPolicy struct {
+	defaultRoute string
+	rules        []anonymousRule
+}
+
+type anonymousRule struct {
+	network *net.IPNet
+	route   string
+}
+
+// NewAnonymousPolicy 创建匿名访问策略。
+// 来源地址按 rules 顺序匹配，命中第一条规则即使用其路由；均未命中时使用 defaultRoute，
+// defaultRoute 为空表示拒绝。非注册 key 的路由在此校验，配置错误在启动时即返回。
+func NewAnonymousPolicy(defaultRoute string, rules []AnonymousRule) (*AnonymousPolicy, error) {
+	if err := validateAnonymousRoute(defaultRoute); err != nil {
+		return nil, fmt.Errorf("匿名默认路由无效: %w", err)
+	}
+	p := &AnonymousPolicy{defaultRoute: defaultRoute}
+	for i, r := range rules {
+		_, network, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("匿名路由第 %d 条 CIDR 无效: %w", i+1, err)
+		}
+		if r.Route == "" {
+			return nil, fmt.Errorf("匿名路由第 %d 条缺少 route", i+1)
+		}
+		if err := validateAnonymousRoute(r.Route); err != nil {
+			return nil, fmt.Errorf("匿名路由第 %d 条 route 无效: %w", i+1, err)
+		}
+		p.rules = append(p.rules, anonymousRule{network: network, route: r.Route})
+	}
+	return p, nil
+}
+
+// Route 返回来源地址 remoteAddr（host:port）对应的下游代理地址，拒绝时返回空字符串。
+func (p *AnonymousPolicy) Route(remoteAddr string) string {
+	if p == nil {
+		return ""
+	}
+	route := p.defaultRoute
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, r := range p.rules {
+			if r.network.Contains(ip) {
+				route = r.route
+				break
+			}
+		}
+	}
+	return resolveRoute(route)
+}
+
+// validateAnonymousRoute 校验匿名路由：空路由与已注册的 key 直接通过，其余按路由解析并校验。
+func validateAnonymousRoute(route string) error {
+	if route == "" || HasUser(route) {
+		return nil
+	}
+	r, err := DecodeRoute(route)
+	if err != nil {
+		return err
+	}
+	return r.Validate()
+}
+
+// resolveRoute 将注册 key 解析为其下游代理地址，非注册 key 原样返回。
+func resolveRoute(route string) string {
+	if route == "" {
+		return ""
+	}
+	userProxyMapLock.RLock()
+	defer userProxyMapLock.RUnlock()
+	if addr, ok := UserProxyMap[route+":"+route]; ok {
+		return addr
+	}
+	return route
+}
@@ -0,0 +1,120 @@
+package gost
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestAnonymousPolicyRoute 测试匿名策略的路由选择
+func TestAnonymousPolicyRoute(t *testing.T) {
+	userProxyMapLock.Lock()
+	UserProxyMap["anonkey:anonkey"] = "100.64.0.9:8939"
+	userProxyMapLock.Unlock()
+
+	policy, err := NewAnonymousPolicy("anonkey", []AnonymousRule{
+		{CIDR: "10.0.0.0/8", Route: "socks5://10.1.1.1:1080"},
+		{CIDR: "fd00::/8", Route: "http://[fd00::1]:8080"},
+	})
+	if err != nil {
+		t.Fatalf("创建匿名策略失败: %v", err)
+	}
+	denyAll, _ := NewAnonymousPolicy("", nil)
+
+	tests := []struct {
+		name   string
+		policy *AnonymousPolicy
+		remote string
+		want   string
+	}{
+		{"未配置策略拒绝", nil, "10.2.3.4:5000", ""},
+		{"空默认路由拒绝", denyAll, "10.2.3.4:5000", ""},
+		{"匹配 IPv4 网段", policy, "10.2.3.4:5000", "socks5://10.1.1.1:1080"},
+		{"匹配 IPv6 网段", policy, "[fd00::5]:5000", "http://[fd00::1]:8080"},
+		{"未命中使用默认路由（注册 key）", policy, "192.168.1.1:5000", "100.64.0.9:8939"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Route(tt.remote); got != tt.want {
+				t.Errorf("期望路由 %q，实际 %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := NewAnonymousPolicy("", []AnonymousRule{{CIDR: "not-a-cidr", Route: "x"}}); err == nil {
+		t.Errorf("无效 CIDR 应返回错误")
+	}
+	// 非注册 key 的路由在创建时校验，拼写错误不会留到首次连接才失败
+	for _, route := range []string{"anonkye", "sock5://10.1.1.1:1080", "socks5://10.1.1.1"} {
+		if _, err := NewAnonymousPolicy(route, nil); err == nil {
+			t.Errorf("默认路由 %q 无效，应返回错误", route)
+		}
+		if _, err := NewAnonymousPolicy("", []AnonymousRule{{CIDR: "10.0.0.0/8", Route: route}}); err == nil {
+			t.Errorf("规则路由 %q 无效，应返回错误", route)
+		}
+	}
+}
+
+// TestSOCKS5AnonymousNoAuth 测试 SOCKS5 无认证方法按匿名策略转发
+func TestSOCKS5AnonymousNoAuth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	policy, _ := NewAnonymousPolicy("", []AnonymousRule{{CIDR: "127.0.0.0/8", Route: "socks5://" + downstream}})
+	serverAddr := startSOCKS5ServerWithOptions(t, WithAnonymousPolicy(policy))
+
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{0x05, 0x01, NoAuth})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != NoAuth {
+		t.Fatalf("期望选择无认证方法，实际 %v, err=%v", resp, err)
+	}
+	addr, _ := encodeSOCKS5Addr(echoAddr)
+	conn.Write(append([]byte{0x05, ConnectCmd, 0x00}, addr...))
+	if rep, _, err := readSOCKS5Reply(conn); err != nil || rep != RepSucceeded {
+		t.Fatalf("CONNECT 失败: rep=%d, err=%v", rep, err)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+	}
+	t.Logf("✅ 匿名 SOCKS5 客户端按来源网段路由")
+}
+
+// TestHTTPAnonymousDenied 测试未配置匿名策略时，即使存在无认证的注册节点也返回 407
+func TestHTTPAnonymousDenied(t *testing.T) {
+	userProxyMapLock.Lock()
+	UserProxyMap["barenode:barenode"] = "127.0.0.1:1"
+	userProxyMapLock.Unlock()
+	server := NewHTTPProxyServer(":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接 HTTP 代理失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("期望 407，实际 %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,31 @@
+package gost
+
+import (
+	"bufio"
+	"net"
+)
+
+// bufferedConn 包装 net.Conn，支持在不消费数据的前提下预读（Peek）开头的字节，
+// 用于按协议首字节分发连接。预读过的数据仍会被后续 Read 读到。
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newBufferedConn 创建 bufferedConn，若 conn 已是 bufferedConn 则直接返回。
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	if bc, ok := conn.(*bufferedConn); ok {
+		return bc
+	}
+	return &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Peek 返回接下来的 n 个字节但不推进读取位置。
+func (c *bufferedConn) Peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+// Read 优先读取已缓冲的数据。
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
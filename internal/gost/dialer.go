@@ -0,0 +1,391 @@
+package gost
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dialer 经某条路由建立到目标地址的连接。
+// 各类节点（直连、HTTP、SOCKS5、代理链）均实现该接口，可在其他 Go 程序中直接复用本包的路由能力。
+// ctx 取消或超时会中断建连与下游握手；连接建立后不再受 ctx 约束。
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// DirectDialer 不经下游节点，直接连接目标地址。
+type DirectDialer struct{}
+
+// DialContext 直接连接 addr，域名按解析配置（见 SetDNS）解析，失败原因按目标地址分类。
+// 目标有多个地址时按用户的地址族偏好（见 SetDualStack）依次发起连接，使用最先建立的连接。
+func (DirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	targets, err := resolveAddrs(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialParallel(ctx, network, targets, dialNet)
+	if err != nil {
+		return nil, newTargetError(addr, err)
+	}
+	return conn, nil
+}
+
+// HopDialer 为可作为代理链一层的代理节点，HTTPDialer、SOCKS5Dialer 均实现该接口。
+// 代理链中每一层按自身的协议与凭据握手。
+type HopDialer interface {
+	Dialer
+	// HopAddr 返回节点地址 host:port。
+	HopAddr() string
+	// Tunnel 在已连接到该节点的 conn 上完成认证，并建立到 addr 的隧道。
+	// 返回的连接可能包装了 conn；失败时由调用方关闭 conn。
+	Tunnel(conn net.Conn, addr string) (net.Conn, error)
+}
+
+// HTTPDialer 经 HTTP 代理的 CONNECT 隧道建立连接，Username 非空时携带 Basic 认证。
+// TLSConfig 非 nil 时（https 协议）先与代理完成 TLS 握手，CONNECT 请求与认证信息均经 TLS 发送。
+type HTTPDialer struct {
+	Addr      string // 代理地址 host:port
+	Username  string
+	Password  string
+	Timeout   time.Duration // 与该层完成握手的超时，0 表示不单独限制
+	TLSConfig *tls.Config
+	Node      bool // 为注册节点，节点侧 gost 在同一端口同时支持 SOCKS5，UDP ASSOCIATE 经 SOCKS5 转发
+}
+
+// DialContext 连接 HTTP 代理并通过 CONNECT 建立到 addr 的隧道。
+func (d *HTTPDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialHops(ctx, network, addr, d)
+}
+
+// HopAddr 返回代理地址。
+func (d *HTTPDialer) HopAddr() string { return d.Addr }
+
+func (d *HTTPDialer) hopTimeout() time.Duration { return d.Timeout }
+
+// Tunnel 在 conn 上发送 CONNECT 请求，启用 TLS 时先完成 TLS 握手。
+func (d *HTTPDialer) Tunnel(conn net.Conn, addr string) (net.Conn, error) {
+	if d.TLSConfig != nil {
+		tlsConn := tls.Client(conn, d.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, newHopError(d.Addr, err)
+		}
+		conn = tlsConn
+	}
+	return httpConnect(conn, addr, d.Username, d.Password)
+}
+
+// SOCKS5Dialer 经 SOCKS5 代理的 CONNECT 命令建立连接，Username 非空时使用用户名密码认证。
+type SOCKS5Dialer struct {
+	Addr     string // 代理地址 host:port
+	Username string
+	Password string
+	Timeout  time.Duration // 与该层完成握手的超时，0 表示不单独限制
+}
+
+// DialContext 连接 SOCKS5 代理，完成认证后建立到 addr 的连接。
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialHops(ctx, network, addr, d)
+}
+
+// HopAddr 返回代理地址。
+func (d *SOCKS5Dialer) HopAddr() string { return d.Addr }
+
+func (d *SOCKS5Dialer) hopTimeout() time.Duration { return d.Timeout }
+
+// Tunnel 在 conn 上完成 SOCKS5 认证并发送 CONNECT 请求。
+func (d *SOCKS5Dialer) Tunnel(conn net.Conn, addr string) (net.Conn, error) {
+	if err := socks5ClientHandshake(conn, d.Username, d.Password); err != nil {
+		return nil, err
+	}
+	if err := socks5Connect(conn, addr); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ChainDialer 依次经过多层代理建立连接。
+// 每一层使用自身的协议与凭据，经上一层建立的隧道与下一层握手，如 http://a -> socks5://u:p@b。
+type ChainDialer struct {
+	Base Dialer // 连接第一层所经的 Dialer（如 ssh 节点），为 nil 时直接连接
+	Hops []HopDialer
+}
+
+// DialContext 连接第一层代理，逐层建立隧道后连接 addr。
+func (d *ChainDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(d.Hops) == 0 {
+		return nil, fmt.Errorf("代理链为空")
+	}
+	return dialHopsVia(ctx, d.Base, network, addr, d.Hops...)
+}
+
+// dialHops 连接 hops[0]，依次经每一层建立到下一层的隧道，最后一层连接 addr。
+func dialHops(ctx context.Context, network, addr string, hops ...HopDialer) (net.Conn, error) {
+	return dialHopsVia(ctx, nil, network, addr, hops...)
+}
+
+// dialHopsVia 与 dialHops 相同，base 非 nil 时经 base 连接 hops[0]，hops 为空时经 base 直接连接 addr。
+// 最后一层为 SOCKS5 时，CONNECT 请求中的目标域名按解析配置（见 SetDNS）替换为 IP。
+// 中间层无法连通下一层时按下游节点不可达处理，最后一层的失败原因原样返回。
+func dialHopsVia(ctx context.Context, base Dialer, network, addr string, hops ...HopDialer) (net.Conn, error) {
+	if err := checkStreamNetwork(network); err != nil {
+		return nil, err
+	}
+	if len(hops) == 0 {
+		return base.DialContext(ctx, network, addr)
+	}
+	if _, ok := hops[len(hops)-1].(*SOCKS5Dialer); ok {
+		var err error
+		if addr, err = resolveAddr(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	dialCtx, cancel := hopContext(ctx, hops[0])
+	var raw net.Conn
+	var err error
+	if base != nil {
+		raw, err = base.DialContext(dialCtx, "tcp", hops[0].HopAddr())
+		if err != nil && !errors.Is(err, ErrNodeUnreachable) {
+			err = newHopError(hops[0].HopAddr(), err)
+		}
+	} else {
+		raw, err = dialHop(dialCtx, hops[0].HopAddr())
+	}
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	conn := raw
+	for i, hop := range hops {
+		next := addr
+		if i+1 < len(hops) {
+			next = hops[i+1].HopAddr()
+		}
+		hctx, cancel := hopContext(ctx, hop)
+		// 期限设置在最底层连接上，对各层包装后的连接同样生效
+		err := handshakeContext(hctx, raw, func() error {
+			c, err := hop.Tunnel(conn, next)
+			if err != nil {
+				return err
+			}
+			conn = c
+			return nil
+		})
+		cancel()
+		if err != nil {
+			raw.Close()
+			if i+1 < len(hops) {
+				return nil, fmt.Errorf("经第 %d 层代理连接下一层失败: %w", i+1, newHopError(next, err))
+			}
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// hopTimeouter 由支持单层握手超时的节点实现。
+type hopTimeouter interface {
+	hopTimeout() time.Duration
+}
+
+// hopContext 为与单层节点的握手派生 ctx，节点设置了超时时叠加该超时。
+func hopContext(ctx context.Context, hop HopDialer) (context.Context, context.CancelFunc) {
+	if t, ok := hop.(hopTimeouter); ok && t.hopTimeout() > 0 {
+		return context.WithTimeout(ctx, t.hopTimeout())
+	}
+	return ctx, func() {}
+}
+
+// ParseRoute 解析路由（JSON 或旧式字符串，见 DecodeRoute），校验后创建 Dialer。
+func ParseRoute(route string) (Dialer, error) {
+	r, err := DecodeRoute(route)
+	if err != nil {
+		return nil, err
+	}
+	return r.Dialer()
+}
+
+// checkStreamNetwork 校验经代理建连的网络类型，代理节点只支持 TCP。
+func checkStreamNetwork(network string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return nil
+	default:
+		return fmt.Errorf("不支持的网络类型: %s", network)
+	}
+}
+
+// ========== Dialer 缓存 ===========
+// dialerCache 按路由字符串缓存解析后的 Dialer，UserProxyMap 整体重新加载时清空，单个 key 变更时只移除其旧路由。
+// dialerCacheGen 在每次清空或移除时递增，依赖 Dialer 的其他缓存（如 transportPool）据此清理失效条目。
+var (
+	dialerCache     = make(map[string]Dialer)
+	dialerCacheLock sync.Mutex
+	dialerCacheGen  atomic.Uint64
+)
+
+// DialerFor 返回路由对应的 Dialer，同一路由只解析一次，直到该路由从缓存中移除。
+func DialerFor(route string) (Dialer, error) {
+	dialerCacheLock.Lock()
+	defer dialerCacheLock.Unlock()
+	if d, ok := dialerCache[route]; ok {
+		return d, nil
+	}
+	d, err := ParseRoute(route)
+	if err != nil {
+		return nil, err
+	}
+	dialerCache[route] = d
+	return d, nil
+}
+
+// DialerForUser 按用户名密码查找路由并返回对应的 Dialer，未注册时返回 ErrNotAllowed。
+func DialerForUser(username, password string) (Dialer, error) {
+	userProxyMapLock.RLock()
+	route := UserProxyMap[username+":"+password]
+	userProxyMapLock.RUnlock()
+	if route == "" {
+		return nil, fmt.Errorf("用户 %s 未注册: %w", username, ErrNotAllowed)
+	}
+	return DialerFor(route)
+}
+
+// resetDialerCache 清空 Dialer 缓存，在 UserProxyMap 整体重新加载后调用。
+func resetDialerCache() {
+	dialerCacheLock.Lock()
+	defer dialerCacheLock.Unlock()
+	dialerCache = make(map[string]Dialer)
+	dialerCacheGen.Add(1)
+}
+
+// invalidateDialers 从缓存中移除 routes 对应的 Dialer，在单个注册 key 的路由变更后调用，
+// 其余路由的 Dialer（如 SSH 持久连接、节点池的轮询状态）不受影响；仍被其他 key 使用的路由保留。
+// 调用方须持有 userProxyMapLock。
+func invalidateDialers(routes ...string) {
+	inUse := make(map[string]bool, len(routes))
+	for _, route := range UserProxyMap {
+		inUse[route] = true
+	}
+	dialerCacheLock.Lock()
+	defer dialerCacheLock.Unlock()
+	for _, route := range routes {
+		if !inUse[route] {
+			delete(dialerCache, route)
+		}
+	}
+	dialerCacheGen.Add(1)
+}
+
+// cachedDialer 返回缓存中路由对应的 Dialer，未缓存时返回 nil。
+func cachedDialer(route string) Dialer {
+	dialerCacheLock.Lock()
+	defer dialerCacheLock.Unlock()
+	return dialerCache[route]
+}
+
+// ========== tailnet 建连 ===========
+// tailnetPrefixes 为 Tailscale 分配节点地址的网段。
+var tailnetPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+}
+
+var (
+	tailnetLock   sync.RWMutex
+	tailnetDialer Dialer
+)
+
+// SetTailnetDialer 设置连接 tailnet 地址（100.64.0.0/10、fd7a:115c:a1e0::/48）使用的 Dialer，
+// 如进程内的 tsnet 节点；为 nil（默认）时经系统网络连接，由内核中的 tailscaled 转发。
+func SetTailnetDialer(d Dialer) {
+	tailnetLock.Lock()
+	defer tailnetLock.Unlock()
+	tailnetDialer = d
+}
+
+// dialNet 连接 addr，addr 为记录了 IPv6 地址的注册节点时按用户的地址族偏好与其 IPv6 地址竞速（见 SetNodeIPv6）。
+func dialNet(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialParallel(ctx, network, nodeAddrs(ctx, network, addr), dialAddr)
+}
+
+// dialAddr 连接单个地址：addr 为 tailnet 地址且设置了 tailnet Dialer 时经该 Dialer，否则经系统网络。
+func dialAddr(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d := tailnetDialerFor(addr); d != nil {
+		if DialTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, DialTimeout)
+			defer cancel()
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	d := net.Dialer{Timeout: DialTimeout}
+	return d.DialContext(ctx, network, addr)
+}
+
+func tailnetDialerFor(addr string) Dialer {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil
+	}
+	tailnetLock.RLock()
+	d := tailnetDialer
+	tailnetLock.RUnlock()
+	if d == nil {
+		return nil
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range tailnetPrefixes {
+		if p.Contains(ip) {
+			return d
+		}
+	}
+	return nil
+}
+
+// ========== 建连上下文 ===========
+// proxyHeaderKey 为 ctx 中客户端 PROXY protocol 头部的键。
+type proxyHeaderKey struct{}
+
+// withProxyHeader 返回携带 PROXY protocol 头部的 ctx，连接第一层下游节点后、协议握手前发送。
+func withProxyHeader(ctx context.Context, hdr []byte) context.Context {
+	if len(hdr) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, proxyHeaderKey{}, hdr)
+}
+
+// proxyHeaderFromContext 返回 ctx 中的 PROXY protocol 头部，未设置时为 nil。
+func proxyHeaderFromContext(ctx context.Context) []byte {
+	hdr, _ := ctx.Value(proxyHeaderKey{}).([]byte)
+	return hdr
+}
+
+// handshakeContext 在 conn 上执行下游握手 fn，ctx 截止或取消时通过读写期限中断阻塞的握手；
+// 不支持读写期限的连接（如 SSH 通道）直接关闭。握手成功后清除期限；失败时由调用方关闭连接。
+func handshakeContext(ctx context.Context, conn net.Conn, fn func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		if conn.SetDeadline(time.Unix(1, 0)) != nil {
+			conn.Close()
+		}
+	})
+	err := fn()
+	if !stop() {
+		// ctx 已结束，期限已被设置为过去的时间
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+	if err == nil {
+		conn.SetDeadline(time.Time{})
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestParseRoute 测试路由字符串到各类 Dialer 的解析
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		check   func(Dialer) bool
+		wantErr bool
+	}{
+		{"直连", "direct", func(d Dialer) bool { _, ok := d.(DirectDialer); return ok }, false},
+		{"裸地址默认 HTTP", "100.64.0.5:8939", func(d Dialer) bool {
+			h, ok := d.(*HTTPDialer)
+			return ok && h.Addr == "100.64.0.5:8939" && h.Username == ""
+		}, false},
+		{"HTTP 带认证", "http://u:p@10.0.0.1:8080", func(d Dialer) bool {
+			h, ok := d.(*HTTPDialer)
+			return ok && h.Addr == "10.0.0.1:8080" && h.Username == "u" && h.Password == "p"
+		}, false},
+		{"SOCKS5", "socks5://u:p@10.0.0.1:1080", func(d Dialer) bool {
+			s, ok := d.(*SOCKS5Dialer)
+			return ok && s.Addr == "10.0.0.1:1080" && s.Username == "u"
+		}, false},
+		{"代理链", "socks5://10.0.0.1:1080 -> http://10.0.0.2:8080", func(d Dialer) bool {
+			c, ok := d.(*ChainDialer)
+			return ok && len(c.Hops) == 2
+		}, false},
+		{"不支持的协议", "ftp://10.0.0.1:21", nil, true},
+		{"代理链只有一层", "socks5://10.0.0.1:1080 ->", nil, true},
+		{"代理链包含不支持的协议", "socks5://10.0.0.1:1080 -> socks4://10.0.0.2:1080", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ParseRoute(tt.route)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望出错=%v，实际 err=%v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !tt.check(d) {
+				t.Errorf("解析结果不符合预期: %#v", d)
+			}
+		})
+	}
+}
+
+// TestDialerForCache 测试 Dialer 按路由缓存，单个 key 的路由变更只重新解析该 key 的旧路由
+func TestDialerForCache(t *testing.T) {
+	route := "socks5://10.9.9.9:1080"
+	d1, err := DialerFor(route)
+	if err != nil {
+		t.Fatalf("解析路由失败: %v", err)
+	}
+	d2, _ := DialerFor(route)
+	if d1 != d2 {
+		t.Errorf("同一路由应返回缓存的 Dialer")
+	}
+	AddUserToProxyMap("dialercache", "100.64.0.19")
+	old, _ := DialerForUser("dialercache", "dialercache")
+	AddUserToProxyMap("dialercache", "100.64.0.10")
+	if d3, _ := DialerFor(route); d1 != d3 {
+		t.Errorf("其他 key 的路由变更不应清除无关路由的 Dialer")
+	}
+	if again, _ := DialerFor("100.64.0.19:8939"); again == old {
+		t.Errorf("key 的路由变更后应重新解析其旧路由")
+	}
+
+	d, err := DialerForUser("dialercache", "dialercache")
+	if err != nil {
+		t.Fatalf("查找注册用户失败: %v", err)
+	}
+	if h, ok := d.(*HTTPDialer); !ok || h.Addr != "100.64.0.10:8939" {
+		t.Errorf("期望经注册节点的 HTTPDialer，实际 %#v", d)
+	}
+	if _, err := DialerForUser("nosuchuser", "x"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("未注册用户应返回 ErrNotAllowed，实际 %v", err)
+	}
+}
+
+// TestDialerDialContext 测试直连与 SOCKS5 Dialer 建立的连接可正常收发
+func TestDialerDialContext(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+
+	tests := []struct {
+		name  string
+		route string
+	}{
+		{"直连", "direct"},
+		{"SOCKS5", "socks5://" + downstream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ParseRoute(tt.route)
+			if err != nil {
+				t.Fatalf("解析路由失败: %v", err)
+			}
+			conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("建连失败: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+			}
+		})
+	}
+
+	if _, err := (&SOCKS5Dialer{Addr: downstream}).DialContext(context.Background(), "udp", echoAddr); err == nil {
+		t.Errorf("代理节点不应支持 udp 网络类型")
+	}
+}
+
+// TestDialerContextCancel 测试下游握手无响应时 ctx 超时能中断建连
+func TestDialerContextCancel(t *testing.T) {
+	// 下游节点接受连接后不做任何应答
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dialers := map[string]Dialer{
+		"HTTP":   &HTTPDialer{Addr: ln.Addr().String()},
+		"SOCKS5": &SOCKS5Dialer{Addr: ln.Addr().String()},
+	}
+	for name, d := range dialers {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if _, err := d.DialContext(ctx, "tcp", "example.com:80"); err == nil {
+				t.Fatal("期望握手超时返回错误")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("ctx 超时后未及时返回: %v", elapsed)
+			}
+			t.Logf("✅ 握手在 %v 后被中断", time.Since(start))
+		})
+	}
+}
+
+// redirectDialer 记录请求的地址，并将连接转到 target
+type redirectDialer struct {
+	target string
+	addrs  chan string
+}
+
+func (d *redirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.addrs <- addr
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, d.target)
+}
+
+// TestTailnetDialer 测试 tailnet 地址经 SetTailnetDialer 设置的 Dialer 连接，其余地址经系统网络
+func TestTailnetDialer(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	rd := &redirectDialer{target: echoAddr, addrs: make(chan string, 8)}
+	SetTailnetDialer(rd)
+	defer SetTailnetDialer(nil)
+
+	for _, addr := range []string{"100.64.0.9:8939", "[fd7a:115c:a1e0::9]:8939"} {
+		conn, err := DirectDialer{}.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatalf("经 tailnet Dialer 建连失败: %v", err)
+		}
+		echoRoundTrip(t, conn, []byte("ping"))
+		conn.Close()
+		if got := <-rd.addrs; got != addr {
+			t.Errorf("tailnet Dialer 期望收到 %s，实际 %s", addr, got)
+		}
+	}
+
+	// 注册节点经 HTTP 代理握手时同样经 tailnet Dialer 连接
+	(&HTTPDialer{Addr: "100.100.1.2:8939"}).DialContext(context.Background(), "tcp", "example.com:443")
+	if got := <-rd.addrs; got != "100.100.1.2:8939" {
+		t.Errorf("tailnet Dialer 期望收到节点地址，实际 %s", got)
+	}
+
+	conn, err := DirectDialer{}.DialContext(context.Background(), "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("直连失败: %v", err)
+	}
+	conn.Close()
+	select {
+	case got := <-rd.addrs:
+		t.Errorf("非 tailnet 地址不应经 tailnet Dialer，实际收到 %s", got)
+	default:
+	}
+}
@@ -0,0 +1,265 @@
+package gost
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 建连时优先尝试的地址族
+const (
+	FamilyIPv4 = "ipv4" // 默认
+	FamilyIPv6 = "ipv6"
+)
+
+// DefaultFallbackDelay 为 RFC 8305 建议的连接尝试间隔。
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// DualStackConfig 为连接注册节点与直连目标时的地址族偏好。
+// 目标或节点同时有 IPv4 与 IPv6 地址时按 RFC 8305（Happy Eyeballs）交替两个地址族依次发起连接，
+// 首个尝试在 fallback_delay 内未建立连接时开始下一个尝试，使用最先建立的连接。
+type DualStackConfig struct {
+	Prefer        string            `yaml:"prefer"`         // 优先的地址族：ipv4（默认）或 ipv6
+	UserPrefer    map[string]string `yaml:"user_prefer"`    // 按注册 key 的地址族偏好
+	FallbackDelay time.Duration     `yaml:"fallback_delay"` // 连接尝试间隔，0 使用默认值（250ms）
+}
+
+// dualStackState 为生效的地址族偏好与各注册节点的 IPv6 地址。
+type dualStackState struct {
+	prefer     string
+	userPrefer map[string]string
+	delay      time.Duration
+	nodeIPv6   map[string]string // 注册节点地址（路由中使用的地址）-> 该节点的 IPv6 地址
+}
+
+var (
+	dualStackLock sync.RWMutex
+	dualStack     = &dualStackState{prefer: FamilyIPv4, delay: DefaultFallbackDelay, nodeIPv6: make(map[string]string)}
+)
+
+// SetDualStack 校验并设置地址族偏好，已记录的节点 IPv6 地址保持不变。
+func SetDualStack(cfg DualStackConfig) error {
+	st := &dualStackState{prefer: cfg.Prefer, userPrefer: make(map[string]string, len(cfg.UserPrefer)), delay: cfg.FallbackDelay}
+	if st.prefer == "" {
+		st.prefer = FamilyIPv4
+	}
+	if err := checkFamily(st.prefer); err != nil {
+		return err
+	}
+	for key, family := range cfg.UserPrefer {
+		if err := checkFamily(family); err != nil {
+			return fmt.Errorf("注册 key %s: %w", key, err)
+		}
+		st.userPrefer[key] = family
+	}
+	if st.delay < 0 {
+		return fmt.Errorf("fallback_delay 不能为负数: %s", st.delay)
+	}
+	if st.delay == 0 {
+		st.delay = DefaultFallbackDelay
+	}
+	dualStackLock.Lock()
+	defer dualStackLock.Unlock()
+	st.nodeIPv6 = dualStack.nodeIPv6
+	dualStack = st
+	return nil
+}
+
+func checkFamily(family string) error {
+	switch family {
+	case FamilyIPv4, FamilyIPv6:
+		return nil
+	default:
+		return fmt.Errorf("不支持的地址族偏好: %q", family)
+	}
+}
+
+// SetNodeIPv6 记录注册节点 ip（路由中使用的地址）的 IPv6 地址，连接该节点时与 ip 按地址族偏好竞速；ip6 为空时删除记录。
+func SetNodeIPv6(ip, ip6 string) {
+	dualStackLock.Lock()
+	defer dualStackLock.Unlock()
+	st := *dualStack
+	st.nodeIPv6 = make(map[string]string, len(dualStack.nodeIPv6)+1)
+	for k, v := range dualStack.nodeIPv6 {
+		st.nodeIPv6[k] = v
+	}
+	if ip6 == "" {
+		delete(st.nodeIPv6, ip)
+	} else {
+		st.nodeIPv6[ip] = ip6
+	}
+	dualStack = &st
+}
+
+// setNodeIPv6Map 整体替换各注册节点的 IPv6 地址，在从数据库加载注册记录后调用。
+func setNodeIPv6Map(m map[string]string) {
+	dualStackLock.Lock()
+	defer dualStackLock.Unlock()
+	st := *dualStack
+	st.nodeIPv6 = m
+	dualStack = &st
+}
+
+func loadDualStack() *dualStackState {
+	dualStackLock.RLock()
+	defer dualStackLock.RUnlock()
+	return dualStack
+}
+
+// hasUserFamily 判断注册 key 是否配置了地址族偏好，此时经该 key 建立的连接不能与其他用户复用。
+func hasUserFamily(key string) bool {
+	_, ok := loadDualStack().userPrefer[key]
+	return key != "" && ok
+}
+
+// family 返回注册 key 的地址族偏好。
+func (st *dualStackState) family(key string) string {
+	if f, ok := st.userPrefer[key]; ok && key != "" {
+		return f
+	}
+	return st.prefer
+}
+
+// orderIPs 按 RFC 8305 排列候选地址：先按 network（tcp4/tcp6）筛选，再从 family 开始交替两个地址族，同一地址族内保持原有顺序。
+func orderIPs(ips []net.IP, network, family string) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch {
+	case strings.HasSuffix(network, "4"):
+		v6 = nil
+	case strings.HasSuffix(network, "6"):
+		v4 = nil
+	}
+	first, second := v4, v6
+	if family == FamilyIPv6 {
+		first, second = v6, v4
+	}
+	ordered := make([]net.IP, 0, len(v4)+len(v6))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// nodeAddrs 返回连接 addr 的候选地址：addr 为记录了 IPv6 地址的注册节点时，按 ctx 中用户的地址族偏好排列两个地址。
+func nodeAddrs(ctx context.Context, network, addr string) []string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}
+	}
+	st := loadDualStack()
+	ip6, ok := st.nodeIPv6[host]
+	if !ok {
+		return []string{addr}
+	}
+	ips := orderIPs([]net.IP{net.ParseIP(host), net.ParseIP(ip6)}, network, st.family(userParamsFromContext(ctx).Key))
+	if len(ips) == 0 {
+		return []string{addr}
+	}
+	return joinHostPorts(ips, port)
+}
+
+// resolveAddrs 解析直连目标 addr 的全部地址（域名按解析配置解析，见 SetDNS），按 ctx 中用户的地址族偏好排列。
+func resolveAddrs(ctx context.Context, network, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}, nil
+	}
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, &DialError{Addr: addr, Kind: ErrHostUnreachable, Err: err}
+	}
+	ips = orderIPs(ips, network, loadDualStack().family(userParamsFromContext(ctx).Key))
+	if len(ips) == 0 {
+		return nil, &DialError{Addr: addr, Kind: ErrHostUnreachable, Err: fmt.Errorf("%s 没有 %s 地址", host, network)}
+	}
+	return joinHostPorts(ips, port), nil
+}
+
+func joinHostPorts(ips []net.IP, port string) []string {
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return addrs
+}
+
+// dialParallel 按 RFC 8305 依次向 addrs 发起连接：上一个尝试在连接尝试间隔内未完成或已失败时开始下一个，
+// 返回最先建立的连接并取消其余尝试；全部失败时返回第一个尝试的错误。
+func dialParallel(ctx context.Context, network string, addrs []string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	if len(addrs) == 1 {
+		return dial(ctx, network, addrs[0])
+	}
+	delay := loadDualStack().delay
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+		i    int
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	var timer <-chan time.Time
+	start := func() {
+		i := next
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, network, addrs[i])
+			results <- result{conn, err, i}
+		}()
+		timer = nil
+		if next < len(addrs) {
+			timer = time.After(delay)
+		}
+	}
+	start()
+	errs := make([]error, len(addrs))
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// 其余尝试在取消前建立的连接直接关闭
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			errs[r.i] = r.err
+			if next < len(addrs) {
+				start()
+			} else if pending == 0 {
+				for _, err := range errs {
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		case <-timer:
+			start()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSetDualStack 测试地址族偏好配置校验
+func TestSetDualStack(t *testing.T) {
+	defer SetDualStack(DualStackConfig{})
+	tests := []struct {
+		name    string
+		cfg     DualStackConfig
+		wantErr bool
+	}{
+		{"默认", DualStackConfig{}, false},
+		{"优先 ipv6", DualStackConfig{Prefer: FamilyIPv6, FallbackDelay: 100 * time.Millisecond}, false},
+		{"按用户偏好", DualStackConfig{UserPrefer: map[string]string{"k": FamilyIPv6}}, false},
+		{"未知地址族", DualStackConfig{Prefer: "ipv5"}, true},
+		{"用户地址族无效", DualStackConfig{UserPrefer: map[string]string{"k": "v6"}}, true},
+		{"间隔为负数", DualStackConfig{FallbackDelay: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetDualStack(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("期望错误=%v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestOrderIPs 测试候选地址按地址族偏好交替排列与按 network 筛选
+func TestOrderIPs(t *testing.T) {
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("fd7a::1"), net.ParseIP("fd7a::2")}
+	tests := []struct {
+		name    string
+		network string
+		family  string
+		want    []string
+	}{
+		{"优先 ipv4", "tcp", FamilyIPv4, []string{"10.0.0.1", "fd7a::1", "10.0.0.2", "fd7a::2"}},
+		{"优先 ipv6", "tcp", FamilyIPv6, []string{"fd7a::1", "10.0.0.1", "fd7a::2", "10.0.0.2"}},
+		{"只要 ipv4", "tcp4", FamilyIPv6, []string{"10.0.0.1", "10.0.0.2"}},
+		{"只要 ipv6", "tcp6", FamilyIPv4, []string{"fd7a::1", "fd7a::2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ip := range orderIPs(ips, tt.network, tt.family) {
+				got = append(got, ip.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("期望 %v，实际 %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestDialParallel 测试 Happy Eyeballs 的连接尝试间隔、失败回退与错误返回
+func TestDialParallel(t *testing.T) {
+	if err := SetDualStack(DualStackConfig{FallbackDelay: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("设置地址族偏好失败: %v", err)
+	}
+	defer SetDualStack(DualStackConfig{})
+	echoAddr := startEchoServer(t)
+	errRefused := errors.New("refused")
+
+	// dial 按地址模拟各尝试：hang 阻塞到取消，refused 立即失败，其余连接回显服务器
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		switch addr {
+		case "hang":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "refused":
+			return nil, errRefused
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, echoAddr)
+	}
+
+	t.Run("首个尝试无响应时在间隔后回退", func(t *testing.T) {
+		start := time.Now()
+		conn, err := dialParallel(context.Background(), "tcp", []string{"hang", "echo"}, dial)
+		if err != nil {
+			t.Fatalf("建连失败: %v", err)
+		}
+		defer conn.Close()
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("应在连接尝试间隔后才开始下一个尝试，实际 %s", elapsed)
+		}
+		echoRoundTrip(t, conn, []byte("fallback"))
+	})
+
+	t.Run("首个尝试失败时立即回退", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 40*time.Millisecond)
+		defer cancel()
+		conn, err := dialParallel(ctx, "tcp", []string{"refused", "echo"}, dial)
+		if err != nil {
+			t.Fatalf("首个尝试失败后应立即开始下一个尝试: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("全部失败返回首个错误", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := dialParallel(ctx, "tcp", []string{"refused", "hang"}, dial); !errors.Is(err, errRefused) {
+			t.Errorf("期望首个尝试的错误，实际 %v", err)
+		}
+	})
+}
+
+// TestNodeDualStack 测试连接记录了 IPv6 地址的注册节点时按用户偏好竞速两个地址族
+func TestNodeDualStack(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("本机不支持 IPv6: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	// 节点的 IPv4 地址上没有监听，只有 IPv6 地址可达
+	node := "127.0.0.1"
+	if err := SetDualStack(DualStackConfig{UserPrefer: map[string]string{"v6user": FamilyIPv6}}); err != nil {
+		t.Fatalf("设置地址族偏好失败: %v", err)
+	}
+	defer SetDualStack(DualStackConfig{})
+	SetNodeIPv6(node, "::1")
+	defer SetNodeIPv6(node, "")
+
+	userCtx := withUserParams(context.Background(), UserParams{Key: "v6user"})
+	if got, want := nodeAddrs(userCtx, "tcp", net.JoinHostPort(node, port)), []string{"[::1]:" + port, "127.0.0.1:" + port}; !reflect.DeepEqual(got, want) {
+		t.Errorf("优先 ipv6 的用户期望候选地址 %v，实际 %v", want, got)
+	}
+	if got := nodeAddrs(context.Background(), "tcp4", net.JoinHostPort(node, port)); !reflect.DeepEqual(got, []string{"127.0.0.1:" + port}) {
+		t.Errorf("tcp4 只应尝试 IPv4 地址，实际 %v", got)
+	}
+	if !hasUserFamily("v6user") || hasUserFamily("other") {
+		t.Errorf("hasUserFamily 结果错误")
+	}
+
+	for _, ctx := range []context.Context{context.Background(), userCtx} {
+		conn, err := DirectDialer{}.DialContext(ctx, "tcp", net.JoinHostPort(node, port))
+		if err != nil {
+			t.Fatalf("应回退到节点的 IPv6 地址: %v", err)
+		}
+		if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "::1" {
+			t.Errorf("期望连接 ::1，实际 %s", got)
+		}
+		conn.Close()
+	}
+	t.Logf("✅ 注册节点的 IPv4 与 IPv6 地址按偏好竞速")
+}
@@ -0,0 +1,133 @@
+package gost
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// 建连失败的分类错误，可通过 errors.Is 判断，并映射为 SOCKS5 应答码。
+var (
+	ErrGeneralFailure       = errors.New("general failure")
+	ErrNodeUnreachable      = errors.New("downstream proxy unreachable")
+	ErrNotAllowed           = errors.New("connection not allowed")
+	ErrNetworkUnreachable   = errors.New("network unreachable")
+	ErrHostUnreachable      = errors.New("host unreachable")
+	ErrConnectionRefused    = errors.New("connection refused")
+	ErrTTLExpired           = errors.New("TTL expired")
+	ErrCommandNotSupported  = errors.New("command not supported")
+	ErrAddrTypeNotSupported = errors.New("address type not supported")
+)
+
+// DialError 描述一次经下游代理建连失败的原因。
+// Kind 为上述分类错误之一，Err 为底层原始错误，两者均可通过 errors.Is/As 匹配。
+type DialError struct {
+	Addr string // 建连失败的地址（下游代理或目标地址）
+	Kind error
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return e.Addr + ": " + e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// newHopError 包装连接下游代理本身失败的错误。
+// 下游代理（注册节点）不可达属于代理服务自身的故障，与目标拒绝连接、不可达区分开。
+func newHopError(hopAddr string, err error) error {
+	return &DialError{Addr: hopAddr, Kind: ErrNodeUnreachable, Err: err}
+}
+
+// newTargetError 根据原始网络错误的类型包装连接目标地址失败的错误。
+func newTargetError(targetAddr string, err error) error {
+	return &DialError{Addr: targetAddr, Kind: classifyDialError(err), Err: err}
+}
+
+// classifyDialError 将原始网络错误归类为分类错误。
+func classifyDialError(err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ErrNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return ErrHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTTLExpired
+	default:
+		return ErrGeneralFailure
+	}
+}
+
+// socks5RepKinds 为下游 SOCKS5 应答码到分类错误的映射，用于逐层透传失败原因。
+var socks5RepKinds = map[byte]error{
+	RepGeneralFailure:       ErrGeneralFailure,
+	RepNotAllowed:           ErrNotAllowed,
+	RepNetworkUnreachable:   ErrNetworkUnreachable,
+	RepHostUnreachable:      ErrHostUnreachable,
+	RepConnectionRefused:    ErrConnectionRefused,
+	RepTTLExpired:           ErrTTLExpired,
+	RepCommandNotSupported:  ErrCommandNotSupported,
+	RepAddrTypeNotSupported: ErrAddrTypeNotSupported,
+}
+
+// socks5RepError 将下游 SOCKS5 代理返回的失败应答码转换为 DialError。
+func socks5RepError(targetAddr string, rep byte) error {
+	kind, ok := socks5RepKinds[rep]
+	if !ok {
+		kind = ErrGeneralFailure
+	}
+	return &DialError{Addr: targetAddr, Kind: kind, Err: errors.New("SOCKS5 connect failed")}
+}
+
+// httpStatusError 将下游 HTTP 代理 CONNECT 的失败状态码转换为 DialError。
+func httpStatusError(targetAddr string, resp *http.Response) error {
+	kind := ErrGeneralFailure
+	switch resp.StatusCode {
+	case http.StatusForbidden:
+		kind = ErrNotAllowed
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		kind = ErrHostUnreachable
+	case http.StatusGatewayTimeout:
+		kind = ErrTTLExpired
+	}
+	return &DialError{Addr: targetAddr, Kind: kind, Err: errors.New("proxy returned " + resp.Status)}
+}
+
+// socks5ReplyCode 返回 err 对应的 SOCKS5 应答码，nil 表示成功。
+// 未经分类的原始错误按 classifyDialError 归类。
+func socks5ReplyCode(err error) byte {
+	if err == nil {
+		return RepSucceeded
+	}
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		err = classifyDialError(err)
+	}
+	switch {
+	case errors.Is(err, ErrNodeUnreachable):
+		return RepGeneralFailure
+	case errors.Is(err, ErrNotAllowed):
+		return RepNotAllowed
+	case errors.Is(err, ErrNetworkUnreachable):
+		return RepNetworkUnreachable
+	case errors.Is(err, ErrHostUnreachable):
+		return RepHostUnreachable
+	case errors.Is(err, ErrConnectionRefused):
+		return RepConnectionRefused
+	case errors.Is(err, ErrTTLExpired):
+		return RepTTLExpired
+	case errors.Is(err, ErrCommandNotSupported):
+		return RepCommandNotSupported
+	case errors.Is(err, ErrAddrTypeNotSupported):
+		return RepAddrTypeNotSupported
+	default:
+		return RepGeneralFailure
+	}
+}
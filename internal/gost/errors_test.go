@@ -0,0 +1,130 @@
+package gost
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSOCKS5ReplyCode 测试分类错误到 SOCKS5 应答码的映射
+func TestSOCKS5ReplyCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want byte
+	}{
+		{"成功", nil, RepSucceeded},
+		{"节点不可达", newHopError("100.64.0.1:8939", syscall.ECONNREFUSED), RepGeneralFailure},
+		{"目标拒绝连接", newTargetError("1.2.3.4:80", syscall.ECONNREFUSED), RepConnectionRefused},
+		{"目标网络不可达", newTargetError("1.2.3.4:80", syscall.ENETUNREACH), RepNetworkUnreachable},
+		{"目标主机不可达", newTargetError("1.2.3.4:80", syscall.EHOSTUNREACH), RepHostUnreachable},
+		{"域名解析失败", newTargetError("nx.example:80", &net.DNSError{Err: "no such host", IsNotFound: true}), RepHostUnreachable},
+		{"下游 SOCKS5 应答透传", socks5RepError("1.2.3.4:80", RepTTLExpired), RepTTLExpired},
+		{"包装后的错误", fmt.Errorf("连接目标地址失败: %w", socks5RepError("1.2.3.4:80", RepNotAllowed)), RepNotAllowed},
+		{"未分类的原始错误", syscall.ECONNREFUSED, RepConnectionRefused},
+		{"未知错误", errors.New("boom"), RepGeneralFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := socks5ReplyCode(tt.err); got != tt.want {
+				t.Errorf("socks5ReplyCode() = %d, 期望 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSOCKS5ConnectReply 测试 CONNECT 应答码与 BND 地址
+func TestSOCKS5ConnectReply(t *testing.T) {
+	// 获取一个已关闭的端口，模拟节点离线/目标拒绝连接
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+	// 可连通的目标
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["nodedown:nodedown"] = "socks5://" + closedAddr
+	UserProxyMap["nodeup:nodeup"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+	serverAddr := startTestSOCKS5Server(t)
+
+	connect := func(user, targetAddr string) (byte, string) {
+		conn, err := net.Dial("tcp", serverAddr)
+		if err != nil {
+			t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		socks5TestLogin(t, conn, user, user)
+		dst, _ := encodeSOCKS5Addr(targetAddr)
+		conn.Write(append([]byte{0x05, ConnectCmd, 0x00}, dst...))
+		rep, bnd, err := readSOCKS5Reply(conn)
+		if err != nil && err != io.EOF {
+			t.Fatalf("读取 CONNECT 应答失败: %v", err)
+		}
+		return rep, bnd
+	}
+
+	if rep, _ := connect("nodedown", target.Addr().String()); rep != RepGeneralFailure {
+		t.Errorf("节点离线期望 REP=%d，实际 %d", RepGeneralFailure, rep)
+	}
+	if rep, _ := connect("nodeup", closedAddr); rep != RepConnectionRefused {
+		t.Errorf("目标拒绝连接期望 REP=%d，实际 %d", RepConnectionRefused, rep)
+	}
+	rep, bnd := connect("nodeup", target.Addr().String())
+	if rep != RepSucceeded {
+		t.Fatalf("期望 CONNECT 成功，实际 REP=%d", rep)
+	}
+	if host, port, _ := net.SplitHostPort(bnd); host != "127.0.0.1" || port == "0" {
+		t.Errorf("期望 BND 为上游连接本地地址，实际 %s", bnd)
+	}
+}
+
+// TestHTTPConnectStatus 测试 HTTP CONNECT 建连失败时按失败原因返回状态码
+func TestHTTPConnectStatus(t *testing.T) {
+	userProxyMapLock.Lock()
+	UserProxyMap["connectstatus:connectstatus"] = "direct"
+	userProxyMapLock.Unlock()
+	httpAddr := startHTTPProxyServerWithOptions(t)
+
+	conn, err := net.Dial("tcp", httpAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 HTTP 代理: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	target := closedAddr(t)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: Basic %s\r\n\r\n",
+		target, target, base64EncodeString("connectstatus:connectstatus"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("读取 CONNECT 响应失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("目标拒绝连接期望 %d，实际 %d", http.StatusBadGateway, resp.StatusCode)
+	}
+}
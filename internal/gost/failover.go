@@ -0,0 +1,130 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultFailoverCooldown 为候选路由失败后默认跳过的时长。
+const DefaultFailoverCooldown = 30 * time.Second
+
+// FailoverCandidate 为故障转移组中的一条候选路由。
+type FailoverCandidate struct {
+	Name    string        // 用于日志与失败记录的名称，不含凭据
+	Dialer  Dialer        // 经该候选建连的 Dialer
+	Timeout time.Duration // 经该候选建连（含各层握手）的超时，0 使用 DialTimeout
+}
+
+// FailoverDialer 按顺序尝试多条候选路由，如注册节点加备用节点或商业代理。
+// 候选因自身故障（节点不可达、握手失败或超时）失败后，在 Cooldown 内排到其余候选之后；
+// 目标地址拒绝或不可达时直接返回，不再尝试其他候选。
+type FailoverDialer struct {
+	Candidates []FailoverCandidate
+	Cooldown   time.Duration // 0 使用 DefaultFailoverCooldown
+}
+
+// DialContext 依次经各候选建连，返回第一个成功的连接；全部失败时返回最后一个错误。
+func (d *FailoverDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(d.Candidates) == 0 {
+		return nil, fmt.Errorf("故障转移组为空")
+	}
+	var lastErr error
+	for _, i := range d.order() {
+		c := d.Candidates[i]
+		conn, err := d.dialCandidate(ctx, c, network, addr)
+		if err == nil {
+			failoverHealth.recover(c.Name)
+			log.Printf("Failover: %s served by candidate %d/%d %s", addr, i+1, len(d.Candidates), c.Name)
+			return conn, nil
+		}
+		if ctx.Err() != nil || isTargetError(err) {
+			return nil, err
+		}
+		cooldown := d.cooldown()
+		failoverHealth.fail(c.Name, cooldown)
+		log.Printf("Failover: candidate %d/%d %s failed, cooling down for %s: %v", i+1, len(d.Candidates), c.Name, cooldown, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (d *FailoverDialer) dialCandidate(ctx context.Context, c FailoverCandidate, network, addr string) (net.Conn, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DialTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return c.Dialer.DialContext(ctx, network, addr)
+}
+
+func (d *FailoverDialer) cooldown() time.Duration {
+	if d.Cooldown > 0 {
+		return d.Cooldown
+	}
+	return DefaultFailoverCooldown
+}
+
+// order 返回本次尝试候选的顺序：未处于冷却期的候选在前，冷却中的候选在后，各自保持配置顺序。
+// 冷却中的候选仍会被尝试，避免全部候选冷却时直接失败。
+func (d *FailoverDialer) order() []int {
+	var ready, cooling []int
+	for i, c := range d.Candidates {
+		if failoverHealth.cooling(c.Name) {
+			cooling = append(cooling, i)
+		} else {
+			ready = append(ready, i)
+		}
+	}
+	return append(ready, cooling...)
+}
+
+// isTargetError 判断建连失败是否由目标地址引起（拒绝连接、不可达等），此类失败换用其他候选也无济于事。
+// 下游节点不可达、握手出错、超时等未分类错误视为候选自身故障。
+func isTargetError(err error) bool {
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		return false
+	}
+	return !errors.Is(err, ErrNodeUnreachable) && !errors.Is(err, ErrGeneralFailure)
+}
+
+// failoverHealth 按候选名称记录失败后的冷却截止时间，不同用户共用同一候选时共享冷却状态，
+// 且不随 Dialer 缓存清空而丢失。
+var failoverHealth = &candidateHealth{until: make(map[string]time.Time)}
+
+type candidateHealth struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (h *candidateHealth) cooling(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	until, ok := h.until[name]
+	if ok && time.Now().After(until) {
+		delete(h.until, name)
+		return false
+	}
+	return ok
+}
+
+func (h *candidateHealth) fail(name string, cooldown time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.until[name] = time.Now().Add(cooldown)
+}
+
+func (h *candidateHealth) recover(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.until, name)
+}
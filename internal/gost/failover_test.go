@@ -0,0 +1,121 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startCountingListener 启动只计数入站连接、不做任何应答的下游节点
+func startCountingListener(t *testing.T) (string, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var n int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&n, 1)
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return ln.Addr().String(), &n
+}
+
+// closedAddr 返回一个当前无人监听的本地地址
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// TestFailoverDialer 测试故障转移组按顺序尝试候选、失败候选进入冷却期
+func TestFailoverDialer(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+
+	t.Run("首个候选不可达时使用备用候选", func(t *testing.T) {
+		dead := closedAddr(t)
+		d, err := ParseRoute("socks5://" + dead + " | socks5://" + downstream)
+		if err != nil {
+			t.Fatalf("解析路由失败: %v", err)
+		}
+		conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("期望经备用候选建连成功: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+		}
+		conn.Close()
+		if !failoverHealth.cooling("socks5://" + dead) {
+			t.Errorf("失败的候选应进入冷却期")
+		}
+		// 冷却中的候选排到最后
+		if order := d.(*FailoverDialer).order(); order[0] != 1 {
+			t.Errorf("冷却中的候选应排在其余候选之后，实际顺序 %v", order)
+		}
+		t.Logf("✅ 备用候选接管，不可达候选进入冷却期")
+	})
+
+	t.Run("单个候选超时", func(t *testing.T) {
+		silent, _ := startCountingListener(t)
+		d, err := ParseRoute(`{"failover":[{"hops":[{"type":"http","addr":"` + silent + `"}],"timeout":"200ms"},{"direct":true}]}`)
+		if err != nil {
+			t.Fatalf("解析路由失败: %v", err)
+		}
+		start := time.Now()
+		conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("期望超时后经直连候选建连成功: %v", err)
+		}
+		conn.Close()
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("候选超时未生效: %v", elapsed)
+		}
+	})
+
+	t.Run("目标地址拒绝连接时不尝试其他候选", func(t *testing.T) {
+		backup, accepted := startCountingListener(t)
+		d, err := ParseRoute("direct | " + backup)
+		if err != nil {
+			t.Fatalf("解析路由失败: %v", err)
+		}
+		_, err = d.DialContext(context.Background(), "tcp", closedAddr(t))
+		if !errors.Is(err, ErrConnectionRefused) {
+			t.Errorf("期望 ErrConnectionRefused，实际 %v", err)
+		}
+		if atomic.LoadInt32(accepted) != 0 {
+			t.Errorf("目标地址的失败不应触发故障转移")
+		}
+		if failoverHealth.cooling("direct") {
+			t.Errorf("目标地址的失败不应使候选进入冷却期")
+		}
+	})
+
+	t.Run("全部候选失败", func(t *testing.T) {
+		d, err := ParseRoute("socks5://" + closedAddr(t) + " | socks5://" + closedAddr(t))
+		if err != nil {
+			t.Fatalf("解析路由失败: %v", err)
+		}
+		if _, err := d.DialContext(context.Background(), "tcp", echoAddr); !errors.Is(err, ErrNodeUnreachable) {
+			t.Errorf("期望 ErrNodeUnreachable，实际 %v", err)
+		}
+	})
+}
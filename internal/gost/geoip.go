@@ -0,0 +1,62 @@
+package gost
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP 从本地 MaxMind DB（mmdb，如 GeoLite2-Country.mmdb）查询 IP 所属国家。
+// 整个文件读入内存，解码由 maxminddb-golang 完成，损坏的数据库在查询时返回错误。
+type GeoIP struct {
+	db *maxminddb.Reader
+}
+
+// geoIPRecord 为国家查询所需的 mmdb 记录字段。
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// OpenGeoIP 读取并解析 mmdb 文件。
+func OpenGeoIP(path string) (*GeoIP, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoIP(buf)
+}
+
+func newGeoIP(buf []byte) (*GeoIP, error) {
+	db, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb 无效: %w", err)
+	}
+	return &GeoIP{db: db}, nil
+}
+
+// Country 返回 ip 所属国家的 ISO 3166-1 代码（大写），未收录时返回空字符串。
+func (g *GeoIP) Country(ip net.IP) (string, error) {
+	if ip.To16() == nil {
+		return "", fmt.Errorf("无效的 IP 地址: %v", ip)
+	}
+	if ip.To4() == nil && g.db.Metadata.IPVersion == 4 {
+		return "", nil
+	}
+	var rec geoIPRecord
+	if err := g.db.Lookup(ip, &rec); err != nil {
+		return "", err
+	}
+	code := rec.Country.ISOCode
+	if code == "" {
+		code = rec.RegisteredCountry.ISOCode
+	}
+	return strings.ToUpper(code), nil
+}
@@ -0,0 +1,174 @@
+package gost
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// 构造测试数据所需的 mmdb 数据段类型与元数据标记
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbUint32  = 6
+	mmdbMap     = 7
+)
+
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbTestNode 为构造测试 mmdb 搜索树的节点，data 为 -1 时表示该分支无记录
+type mmdbTestNode struct {
+	child [2]*mmdbTestNode
+	data  [2]int
+}
+
+// encodeMMDBString、encodeMMDBMap、encodeMMDBUint32 编码测试数据所需的 mmdb 值
+func encodeMMDBString(s string) []byte {
+	return append([]byte{byte(mmdbString<<5 | len(s))}, s...)
+}
+
+func encodeMMDBMap(size int) []byte {
+	return []byte{byte(mmdbMap<<5 | size)}
+}
+
+func encodeMMDBUint32(v uint32) []byte {
+	b := []byte{byte(mmdbUint32<<5 | 4), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], v)
+	return b
+}
+
+// buildTestMMDB 构造记录长度为 24 位的 IPv4 mmdb，nets[i] 对应数据段中偏移 offsets[i] 处的记录
+func buildTestMMDB(t *testing.T, nets []string, offsets []int, data []byte) []byte {
+	t.Helper()
+	newNode := func() *mmdbTestNode { return &mmdbTestNode{data: [2]int{-1, -1}} }
+	root := newNode()
+	for i, cidr := range nets {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("无效的网段 %s: %v", cidr, err)
+		}
+		ones, _ := n.Mask.Size()
+		ip, node := n.IP.To4(), root
+		for j := 0; j < ones; j++ {
+			bit := ip[j/8] >> (7 - uint(j%8)) & 1
+			if j == ones-1 {
+				node.data[bit] = offsets[i]
+				break
+			}
+			if node.child[bit] == nil {
+				node.child[bit] = newNode()
+			}
+			node = node.child[bit]
+		}
+	}
+	// 按广度优先编号节点
+	nodes, index := []*mmdbTestNode{root}, map[*mmdbTestNode]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if c != nil {
+				index[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	count := len(nodes)
+	var buf []byte
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			rec := count
+			if c := n.child[bit]; c != nil {
+				rec = index[c]
+			} else if n.data[bit] >= 0 {
+				rec = count + 16 + n.data[bit]
+			}
+			buf = append(buf, byte(rec>>16), byte(rec>>8), byte(rec))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, mmdbMetadataMarker...)
+	buf = append(buf, encodeMMDBMap(3)...)
+	buf = append(buf, encodeMMDBString("node_count")...)
+	buf = append(buf, encodeMMDBUint32(uint32(count))...)
+	buf = append(buf, encodeMMDBString("record_size")...)
+	buf = append(buf, encodeMMDBUint32(24)...)
+	buf = append(buf, encodeMMDBString("ip_version")...)
+	buf = append(buf, encodeMMDBUint32(4)...)
+	return buf
+}
+
+// newTestGeoIP 返回收录 1.2.3.0/24 为 US、5.6.0.0/16 为 DE（仅 registered_country，经指针引用）的数据库
+func newTestGeoIP(t *testing.T) *GeoIP {
+	t.Helper()
+	var data []byte
+	// 记录 0：{"country": {"iso_code": "US"}}
+	data = append(data, encodeMMDBMap(1)...)
+	data = append(data, encodeMMDBString("country")...)
+	data = append(data, encodeMMDBMap(1)...)
+	data = append(data, encodeMMDBString("iso_code")...)
+	data = append(data, encodeMMDBString("US")...)
+	// DE 的国家信息，由记录 1 经指针引用
+	de := len(data)
+	data = append(data, encodeMMDBMap(1)...)
+	data = append(data, encodeMMDBString("iso_code")...)
+	data = append(data, encodeMMDBString("de")...)
+	// 记录 1：{"registered_country": <指针>}
+	second := len(data)
+	data = append(data, encodeMMDBMap(1)...)
+	data = append(data, encodeMMDBString("registered_country")...)
+	data = append(data, byte(mmdbPointer<<5|de>>8&0x7), byte(de))
+
+	g, err := newGeoIP(buildTestMMDB(t, []string{"1.2.3.0/24", "5.6.0.0/16"}, []int{0, second}, data))
+	if err != nil {
+		t.Fatalf("解析测试 mmdb 失败: %v", err)
+	}
+	return g
+}
+
+// TestGeoIPCountry 测试按 IP 查询国家代码
+func TestGeoIPCountry(t *testing.T) {
+	g := newTestGeoIP(t)
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{"country 字段", "1.2.3.4", "US"},
+		{"registered_country 与指针", "5.6.7.8", "DE"},
+		{"未收录", "9.9.9.9", ""},
+		{"IPv4 数据库查询 IPv6", "2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := g.Country(net.ParseIP(tt.ip))
+			if err != nil || got != tt.want {
+				t.Errorf("期望 %q，实际 %q, err=%v", tt.want, got, err)
+			}
+		})
+	}
+	if _, err := newGeoIP([]byte("not a mmdb")); err == nil {
+		t.Errorf("缺少元数据时应返回错误")
+	}
+}
+
+// TestGeoIPCorrupt 测试损坏的数据段（指向自身的指针、超出数据长度的 map）在查询时返回错误
+func TestGeoIPCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"指针循环", []byte{byte(mmdbPointer << 5), 0}},
+		{"map 长度超出数据", []byte{byte(mmdbMap<<5 | 31), 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newGeoIP(buildTestMMDB(t, []string{"1.2.3.0/24"}, []int{0}, tt.data))
+			if err != nil {
+				t.Fatalf("解析测试 mmdb 失败: %v", err)
+			}
+			if _, err := g.Country(net.ParseIP("1.2.3.4")); err == nil {
+				t.Errorf("期望损坏的记录返回错误")
+			}
+		})
+	}
+}
@@ -1,41 +1,97 @@
 package gost
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"strings"
-	"sync"
+	"time"
 )
 
 // HTTPProxyServer 实现了基于用户名密码动态转发的 HTTP/HTTPS 代理服务。
 // 支持标准 HTTP 代理协议，支持 CONNECT 隧道和普通 HTTP 请求，
 // 可根据用户认证信息动态选择下游代理。
 type HTTPProxyServer struct {
-	addr string
+	addr   string
+	opts   serverOptions
+	server *http.Server
+	// transports 按路由缓存普通 HTTP 转发使用的 Transport
+	transports transportPool
+	state      serverState // 跟踪被劫持的隧道连接，http.Server 不再管理这些连接
 }
 
 // NewHTTPProxyServer 创建一个新的 HTTPProxyServer 实例。
-// 参数 addr 为监听地址（如 ":8081"），返回 HTTPProxyServer 指针。
-func NewHTTPProxyServer(addr string) *HTTPProxyServer {
-	return &HTTPProxyServer{addr: addr}
+// 参数 addr 为监听地址（如 ":8081"），opts 为可选参数（超时等），返回 HTTPProxyServer 指针。
+func NewHTTPProxyServer(addr string, opts ...Option) *HTTPProxyServer {
+	h := &HTTPProxyServer{addr: addr, opts: newServerOptions(opts)}
+	h.transports.idleTimeout = h.opts.idleTimeout
+	// 创建 HTTP 服务器，指定自定义 Handler；请求头须在握手期限内读完，长连接空闲超时后关闭
+	h.server = &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(h.handleRequest),
+		ReadHeaderTimeout: h.opts.handshakeTimeout,
+		IdleTimeout:       h.opts.idleTimeout,
+	}
+	return h
 }
 
 // Start 启动 HTTP 代理服务器，监听指定地址并处理客户端请求。
 // 返回 error 表示启动或运行过程中遇到的错误。
 func (h *HTTPProxyServer) Start() error {
-	// 创建 HTTP 服务器，指定自定义 Handler
-	server := &http.Server{
-		Addr:    h.addr,
-		Handler: http.HandlerFunc(h.handleRequest),
+	listener, err := net.Listen("tcp", h.addr)
+	if err != nil {
+		return err
 	}
 	log.Printf("HTTP 代理已启动，监听地址: %s", h.addr)
-	// 启动监听主循环
-	return server.ListenAndServe()
+	return h.Serve(context.Background(), listener)
+}
+
+// Serve 在 listener 上接受并处理 HTTP 代理请求，直到 ctx 取消或调用 Shutdown。
+// 配置了 WithTLSConfig 时先在连接上完成 TLS 握手，再复用相同的认证与路由逻辑。
+// 服务关闭后返回 ErrServerClosed；已建立的隧道由 Shutdown 负责排空。
+func (h *HTTPProxyServer) Serve(ctx context.Context, listener net.Listener) error {
+	// PROXY 头部位于 TLS 握手之前
+	listener = h.opts.wrapProxyProto(listener)
+	if h.opts.tlsConfig != nil {
+		listener = tls.NewListener(listener, h.opts.tlsConfig)
+	}
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	err := h.server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) || ctx.Err() != nil {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown 优雅关闭 HTTP 代理服务器：停止接收新连接，等待进行中的请求与 CONNECT 隧道结束；
+// ctx 到期时强制关闭剩余连接并返回 ctx.Err()。
+func (h *HTTPProxyServer) Shutdown(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.server.Shutdown(ctx)
+	}()
+	err := h.state.drain(ctx)
+	serr := <-errc
+	h.transports.closeIdle()
+	if serr != nil {
+		h.server.Close()
+		if err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// ActiveSessions 返回当前活跃的隧道会话数。
+func (h *HTTPProxyServer) ActiveSessions() int {
+	return h.state.activeSessions()
 }
 
 // handleRequest 处理所有进入的 HTTP 代理请求。
@@ -63,24 +119,17 @@ func (h *HTTPProxyServer) handleRequest(w http.ResponseWriter, r *http.Request)
 		username = r.URL.User.Username()
 		password, _ = r.URL.User.Password()
 	}
+	var params UserParams
 	if username != "" {
-		userKey := username + ":" + password
-		userProxyMapLock.RLock()
-		proxyAddr = UserProxyMap[userKey]
-		userProxyMapLock.RUnlock()
-	}
-	// 2. 支持匿名转发（无认证时，允许转发到未设置认证的下游 http 代理）
-	if proxyAddr == "" {
-		userProxyMapLock.RLock()
-		for _, v := range UserProxyMap {
-			u, err := url.Parse(v)
-			if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.User == nil {
-				proxyAddr = v
-				log.Printf("HTTP: 匿名用户允许转发到下游 http 代理: %s", proxyAddr)
-				break
-			}
+		// 用户名可携带会话与节点筛选参数，经请求 ctx 传递给下游建连
+		proxyAddr, params = lookupUser(username, password)
+		r = r.WithContext(withUserParams(r.Context(), params))
+	} else {
+		// 2. 未提供认证信息时按匿名策略选择路由，未配置策略则拒绝
+		proxyAddr = h.opts.anonymous.Route(r.RemoteAddr)
+		if proxyAddr != "" {
+			log.Printf("HTTP: 匿名客户端 %s 使用路由: %s", r.RemoteAddr, proxyAddr)
 		}
-		userProxyMapLock.RUnlock()
 	}
 	// 3. 认证失败，返回 407
 	if proxyAddr == "" {
@@ -89,7 +138,16 @@ func (h *HTTPProxyServer) handleRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	log.Printf("HTTP: User %s authenticated, using proxy: %s", username, proxyAddr)
-	// 4. 根据请求类型分发
+	// 4. 按路由规则为目标地址选择路由，命中 reject 规则时返回 403
+	if target := requestTarget(r); target != "" {
+		var err error
+		if proxyAddr, err = applyRules(r.Context(), params.Key, proxyAddr, target); err != nil {
+			log.Printf("HTTP: request to %s refused: %v", target, err)
+			http.Error(w, err.Error(), httpErrorStatus(err))
+			return
+		}
+	}
+	// 5. 根据请求类型分发
 	if r.Method == "CONNECT" {
 		h.handleConnect(w, r, proxyAddr)
 	} else {
@@ -101,16 +159,18 @@ func (h *HTTPProxyServer) handleRequest(w http.ResponseWriter, r *http.Request)
 // 通过下游代理建立到目标主机的隧道，并将客户端与下游代理连接起来。
 // 参数 w 为响应写入器，r 为客户端请求，proxyAddr 为下游代理地址。
 func (h *HTTPProxyServer) handleConnect(w http.ResponseWriter, r *http.Request, proxyAddr string) {
-	// 1. 获取下游代理连接器
-	connector, err := getProxyConnector(proxyAddr)
+	// 1. 获取该路由的 Dialer
+	dialer, err := DialerFor(proxyAddr)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// 2. 通过下游代理建立到目标主机的连接
-	proxyConn, err := connector(r.Host)
+	// 2. 通过下游代理建立到目标主机的连接，客户端断开时中止建连
+	proxyConn, err := dialer.DialContext(h.dialContext(r), "tcp", r.Host)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("HTTP: CONNECT %s via %s failed: %v", r.Host, proxyAddr, err)
+		// 按失败原因返回与普通 HTTP 转发一致的状态码
+		http.Error(w, err.Error(), httpErrorStatus(err))
 		return
 	}
 	defer proxyConn.Close()
@@ -126,6 +186,12 @@ func (h *HTTPProxyServer) handleConnect(w http.ResponseWriter, r *http.Request,
 		return
 	}
 	defer clientConn.Close()
+	if !h.state.addSession(clientConn) {
+		return
+	}
+	defer h.state.removeSession(clientConn)
+	// 劫持后的连接不再受 http.Server 的期限约束，由 relay 负责空闲检测
+	clientConn.SetDeadline(time.Time{})
 	// 4. 通知客户端隧道建立成功
 	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 	// 5. 开始双向转发数据
@@ -133,89 +199,191 @@ func (h *HTTPProxyServer) handleConnect(w http.ResponseWriter, r *http.Request,
 }
 
 // handleHTTP 处理普通 HTTP 请求（非 CONNECT）。
-// 通过下游代理转发 HTTP 请求并将响应返回给客户端。
+// 经该路由的池化 Transport 转发请求，请求体与响应体均以流式转发，逐跳头部在两个方向上移除；
+// 客户端连接的长连接与管线化由 http.Server 处理。
+// 协议升级请求（如 WebSocket、h2c）保留 Connection 与 Upgrade 头，下游返回 101 后改为双向转发。
 // 参数 w 为响应写入器，r 为客户端请求，proxyAddr 为下游代理地址。
 func (h *HTTPProxyServer) handleHTTP(w http.ResponseWriter, r *http.Request, proxyAddr string) {
-	// 1. 获取下游代理连接器
-	connector, err := getProxyConnector(proxyAddr)
+	if r.URL.Host == "" {
+		http.Error(w, "Bad Request: absolute URL required", http.StatusBadRequest)
+		return
+	}
+	// 1. 获取该路由的 Transport
+	// 携带 PROXY 头部、用户名参数或用户配置了 hosts 覆盖、地址族偏好时，经下游建立的连接与该客户端绑定，不能跨客户端复用
+	params := userParamsFromContext(r.Context())
+	perClient := h.opts.proxyHeaderOut != 0 || !params.IsZero() || hasUserHosts(params.Key) || hasUserFamily(params.Key)
+	transport, err := h.transports.get(proxyAddr, perClient)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// 2. 通过下游代理建立到目标主机的连接
-	proxyConn, err := connector(r.URL.Host)
+	if perClient {
+		defer transport.CloseIdleConnections()
+	}
+	// 2. 构造转发请求，移除逐跳头部
+	outReq := r.Clone(h.dialContext(r))
+	outReq.RequestURI = ""
+	outReq.Close = false
+	if r.ContentLength == 0 {
+		outReq.Body = nil
+	}
+	upType := upgradeType(r.Header)
+	removeHopHeaders(outReq.Header)
+	if upType != "" {
+		outReq.Header.Set("Connection", "Upgrade")
+		outReq.Header.Set("Upgrade", upType)
+	}
+	// 3. 经下游代理发送请求
+	resp, err := transport.RoundTrip(outReq)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("HTTP: forward %s via %s failed: %v", r.URL.Host, proxyAddr, err)
+		http.Error(w, err.Error(), httpErrorStatus(err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		h.handleUpgrade(w, resp, upType)
+		return
+	}
+	// 4. 回写响应头，移除逐跳头部
+	removeHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	// 5. 流式转发响应体；长度未知（如分块、SSE）时每次写入后立即刷新
+	copyResponseBody(w, resp.Body, resp.ContentLength == -1)
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+key, value)
+		}
+	}
+}
+
+// handleUpgrade 处理下游的 101 Switching Protocols 应答：劫持客户端连接，回写应答后在客户端与下游连接之间双向转发。
+// upType 为客户端请求升级的协议，下游切换到其他协议时返回 502。
+func (h *HTTPProxyServer) handleUpgrade(w http.ResponseWriter, resp *http.Response, upType string) {
+	if resUpType := upgradeType(resp.Header); upType == "" || !strings.EqualFold(resUpType, upType) {
+		http.Error(w, fmt.Sprintf("下游切换到了未请求的协议 %q", resUpType), http.StatusBadGateway)
 		return
 	}
-	// 3. 劫持客户端连接，转为原始 TCP
-	hj, ok := w.(http.Hijacker)
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
 	if !ok {
-		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		http.Error(w, "下游连接不支持协议升级", http.StatusBadGateway)
 		return
 	}
-	clientConn, _, err := hj.Hijack()
+	clientConn, brw, err := http.NewResponseController(w).Hijack()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// 4. 循环转发 HTTP 请求与响应
-	req := r
+	defer clientConn.Close()
+	if !h.state.addSession(clientConn) {
+		return
+	}
+	defer h.state.removeSession(clientConn)
+	clientConn.SetDeadline(time.Time{})
+	upType = resp.Header.Get("Upgrade")
+	removeHopHeaders(resp.Header)
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", upType)
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		return
+	}
+	// 客户端在收到 101 之前发送的数据留在 brw 的读缓冲中
+	var conn net.Conn = clientConn
+	if brw.Reader.Buffered() > 0 {
+		conn = &bufferedConn{Conn: clientConn, r: brw.Reader}
+	}
+	h.relay(conn, &upgradedConn{ReadWriteCloser: backConn, Conn: clientConn})
+}
+
+// upgradedConn 将 Transport 在 101 应答后返回的下游连接（io.ReadWriteCloser）包装为 net.Conn 供 relay 使用。
+// 下游连接不支持读写期限，空闲检测由客户端一侧的期限完成；地址方法取自客户端连接，仅用于日志。
+type upgradedConn struct {
+	io.ReadWriteCloser
+	net.Conn
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error)       { return c.ReadWriteCloser.Read(p) }
+func (c *upgradedConn) Write(p []byte) (int, error)      { return c.ReadWriteCloser.Write(p) }
+func (c *upgradedConn) Close() error                     { return c.ReadWriteCloser.Close() }
+func (c *upgradedConn) SetDeadline(time.Time) error      { return nil }
+func (c *upgradedConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *upgradedConn) SetWriteDeadline(time.Time) error { return nil }
+
+// dialContext 返回用于下游建连的 ctx，启用下游 PROXY 头部时携带请求的客户端地址与本地监听地址。
+func (h *HTTPProxyServer) dialContext(r *http.Request) context.Context {
+	if h.opts.proxyHeaderOut == 0 {
+		return r.Context()
+	}
+	src, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	dst, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return contextWithProxyHeader(r.Context(), h.opts.proxyHeaderOut, src, dst)
+}
+
+// copyResponseBody 将下游响应体写给客户端，flush 为 true 时每次写入后刷新缓冲。
+func copyResponseBody(w http.ResponseWriter, body io.Reader, flush bool) {
+	if !flush {
+		io.Copy(w, body)
+		return
+	}
+	rc := http.NewResponseController(w)
+	buf := make([]byte, relayBufferSize)
 	for {
-		// 构造新的 HTTP 请求，复制原始请求头
-		newReq, err := http.NewRequest(req.Method, req.URL.String(), req.Body)
-		if err != nil {
-			break
-		}
-		for key, values := range req.Header {
-			for _, value := range values {
-				newReq.Header.Add(key, value)
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
 			}
+			rc.Flush()
 		}
-		// 确保不设置 Proxy-Authorization 头
-		newReq.Header.Del("Proxy-Authorization")
-		// 发送请求到下游代理
-		err = newReq.WriteProxy(proxyConn)
 		if err != nil {
-			break
-		}
-		// 读取下游代理响应
-		resp, err := http.ReadResponse(bufio.NewReader(proxyConn), newReq)
-		if err != nil {
-			break
-		}
-		// 转发响应给客户端
-		resp.Write(clientConn)
-		// 检查连接是否应关闭
-		if resp.Close || newReq.Close {
-			break
-		}
-		// 读取下一个客户端请求（长连接复用）
-		req, err = http.ReadRequest(bufio.NewReader(clientConn))
-		if err != nil {
-			break
+			return
 		}
 	}
-	proxyConn.Close()
-	clientConn.Close()
+}
+
+// requestTarget 返回请求的目标地址（host:port），普通请求未写端口时按 scheme 补全默认端口。
+func requestTarget(r *http.Request) string {
+	if r.Method == "CONNECT" {
+		return r.Host
+	}
+	host := r.URL.Host
+	if host == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if r.URL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// httpErrorStatus 将转发错误映射为返回给客户端的 HTTP 状态码。
+func httpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, ErrTTLExpired), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
 }
 
 // relay 实现两个连接之间的双向数据转发。
-// 用于 CONNECT 隧道和 SOCKS5 隧道的数据转发。
+// 用于 CONNECT 隧道和 SOCKS5 隧道的数据转发，双向空闲超过 idleTimeout 时关闭隧道。
 // 参数 conn1、conn2 为需要互相转发数据的两个连接。
 func (h *HTTPProxyServer) relay(conn1, conn2 net.Conn) {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	// 启动两个 goroutine 实现双向转发
-	go func() {
-		defer wg.Done()
-		io.Copy(conn1, conn2) // 下游 -> 客户端
-		conn1.Close()
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(conn2, conn1) // 客户端 -> 下游
-		conn2.Close()
-	}()
-	wg.Wait()
+	relayConns(conn1, conn2, h.opts.idleTimeout)
 }
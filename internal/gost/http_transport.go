@@ -0,0 +1,140 @@
+package gost
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hopHeaders 为逐跳头部（RFC 7230 6.1），只对单条连接有效，转发前后均须移除。
+// 协议升级请求与 101 应答的 Connection、Upgrade 在移除后重新设置（见 handleHTTP）。
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // 非标准，但常见于旧客户端
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders 移除逐跳头部，以及 Connection 头中声明的其他逐跳头部。
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// upgradeType 返回 Connection 头声明协议升级时 Upgrade 头中的协议（如 websocket、h2c），未声明时返回空字符串。
+func upgradeType(h http.Header) string {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if strings.EqualFold(textproto.TrimString(name), "Upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// transportPool 按路由（下游代理地址）缓存 http.Transport，
+// 同一路由的普通 HTTP 请求复用经下游节点建立的长连接。零值可直接使用。
+// Dialer 缓存变更（UserProxyMap 重新加载或单个 key 的路由变更）后，路由已失效或 Dialer 已更换的 Transport 被关闭并移除。
+type transportPool struct {
+	mu          sync.Mutex
+	transports  map[string]pooledTransport
+	gen         uint64 // 上次清理时的 Dialer 缓存代数
+	idleTimeout time.Duration
+}
+
+// pooledTransport 为缓存的 Transport 及其拨号使用的 Dialer。
+type pooledTransport struct {
+	*http.Transport
+	dialer Dialer
+}
+
+// get 返回 proxyAddr 对应的 Transport，不存在时创建。
+// Transport 经该路由的 Dialer 拨号目标主机，连接按目标主机池化。
+// perClient 为 true（如携带客户端 PROXY 头部）时连接不能跨客户端复用，返回不缓存、不保持长连接的 Transport，
+// 由调用方在请求结束后关闭。
+func (p *transportPool) get(proxyAddr string, perClient bool) (*http.Transport, error) {
+	if perClient {
+		t, err := p.newTransport(proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		t.DisableKeepAlives = true
+		return t.Transport, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if gen := dialerCacheGen.Load(); gen != p.gen {
+		p.sweep()
+		p.gen = gen
+	}
+	if t, ok := p.transports[proxyAddr]; ok {
+		return t.Transport, nil
+	}
+	t, err := p.newTransport(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if p.transports == nil {
+		p.transports = make(map[string]pooledTransport)
+	}
+	p.transports[proxyAddr] = t
+	return t.Transport, nil
+}
+
+// sweep 关闭并移除 Dialer 已不在缓存中的 Transport，调用方须持有 p.mu。
+// 仍在进行的请求不受影响，其连接在请求结束后随 Transport 一并释放。
+func (p *transportPool) sweep() {
+	for route, t := range p.transports {
+		if cachedDialer(route) != t.dialer {
+			t.CloseIdleConnections()
+			delete(p.transports, route)
+		}
+	}
+}
+
+// newTransport 创建经 proxyAddr 拨号的 Transport，请求的 ctx 传递给 Dialer。
+func (p *transportPool) newTransport(proxyAddr string) (pooledTransport, error) {
+	dialer, err := DialerFor(proxyAddr)
+	if err != nil {
+		return pooledTransport{}, err
+	}
+	idle := p.idleTimeout
+	if idle <= 0 {
+		idle = 90 * time.Second
+	}
+	t := &http.Transport{
+		Proxy:                 nil, // 目标连接已经由 Dialer 经下游代理建立
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       idle,
+		ExpectContinueTimeout: time.Second,
+		// 原样转发客户端的 Accept-Encoding，不由代理自动解压
+		DisableCompression: true,
+	}
+	return pooledTransport{Transport: t, dialer: dialer}, nil
+}
+
+// closeIdle 关闭所有 Transport 的空闲连接，用于服务关闭。
+func (p *transportPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
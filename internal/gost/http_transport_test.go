@@ -0,0 +1,214 @@
+package gost
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHTTPProxyPlainForward 测试普通 HTTP 转发：连接池复用、管线化、流式请求体与逐跳头部移除
+func TestHTTPProxyPlainForward(t *testing.T) {
+	// 目标 HTTP 服务：统计新建连接数，回显请求体与收到的头部
+	var newConns atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Seen-Foo", r.Header.Get("X-Foo"))
+		w.Header().Set("X-Seen-Proxy-Auth", r.Header.Get("Proxy-Authorization"))
+		w.Header().Set("X-Seen-Keep", r.Header.Get("X-Keep"))
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Write([]byte(r.URL.Path + ":" + string(body)))
+	}))
+	backend.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["plainhttp:plainhttp"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+
+	server := NewHTTPProxyServer(":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接 HTTP 代理失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 一次性写入三个管线化请求
+	auth := "Proxy-Authorization: Basic " + base64EncodeString("plainhttp:plainhttp") + "\r\n"
+	reqs := "GET http://" + backendHost + "/a HTTP/1.1\r\nHost: " + backendHost + "\r\n" + auth +
+		"Connection: X-Foo\r\nX-Foo: bar\r\nX-Keep: yes\r\n\r\n" +
+		"POST http://" + backendHost + "/b HTTP/1.1\r\nHost: " + backendHost + "\r\n" + auth +
+		"Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n" +
+		"GET http://" + backendHost + "/c HTTP/1.1\r\nHost: " + backendHost + "\r\n" + auth + "\r\n"
+	conn.Write([]byte(reqs))
+
+	br := bufio.NewReader(conn)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"GET 请求", "/a:"},
+		{"分块请求体", "/b:hello world"},
+		{"管线化第三个请求", "/c:"},
+	}
+	for i, tt := range tests {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("%s: 读取响应失败: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("%s: 期望响应体 %q，实际 %q", tt.name, tt.want, body)
+		}
+		if resp.Header.Get("X-Backend-Hop") != "" {
+			t.Errorf("%s: 响应中的逐跳头部未移除", tt.name)
+		}
+		if resp.Header.Get("X-Seen-Proxy-Auth") != "" {
+			t.Errorf("%s: Proxy-Authorization 被转发到目标", tt.name)
+		}
+		if i == 0 {
+			if resp.Header.Get("X-Seen-Foo") != "" {
+				t.Errorf("Connection 中声明的 X-Foo 未移除")
+			}
+			if resp.Header.Get("X-Seen-Keep") != "yes" {
+				t.Errorf("端到端头部 X-Keep 丢失")
+			}
+		}
+	}
+	// 三个请求应复用同一条到目标的连接
+	if n := newConns.Load(); n != 1 {
+		t.Errorf("期望复用 1 条目标连接，实际新建 %d 条", n)
+	}
+	t.Logf("✅ 普通 HTTP 转发通过，目标连接数 %d", newConns.Load())
+}
+
+// TestHTTPProxyUpgrade 测试协议升级（如 WebSocket）：保留 Upgrade 头，101 应答后客户端与目标服务之间双向转发
+func TestHTTPProxyUpgrade(t *testing.T) {
+	// 目标服务：收到 Upgrade: echo 时切换协议并逐行回显
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upgradeType(r.Header) != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString("echo " + line)
+			brw.Flush()
+		}
+	}))
+	defer backend.Close()
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["upgradehttp:upgradehttp"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+
+	server := NewHTTPProxyServer(":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接 HTTP 代理失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET http://" + backendHost + "/ws HTTP/1.1\r\nHost: " + backendHost + "\r\n" +
+		"Proxy-Authorization: Basic " + base64EncodeString("upgradehttp:upgradehttp") + "\r\n" +
+		"Connection: keep-alive, Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || upgradeType(resp.Header) != "echo" {
+		t.Fatalf("期望 101 且 Upgrade: echo，实际 %d %v", resp.StatusCode, resp.Header)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		conn.Write([]byte(msg + "\n"))
+		line, err := br.ReadString('\n')
+		if err != nil || line != "echo "+msg+"\n" {
+			t.Errorf("期望 echo %s，实际 %q, err=%v", msg, line, err)
+		}
+	}
+}
+
+// TestRemoveHopHeaders 测试逐跳头部移除
+func TestRemoveHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "close, X-Custom")
+	h.Set("X-Custom", "1")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("Proxy-Connection", "keep-alive")
+	h.Set("Upgrade", "websocket")
+	h.Set("Content-Type", "text/plain")
+	removeHopHeaders(h)
+	for _, name := range []string{"Connection", "X-Custom", "Keep-Alive", "Proxy-Connection", "Upgrade"} {
+		if h.Get(name) != "" {
+			t.Errorf("%s 未被移除", name)
+		}
+	}
+	if h.Get("Content-Type") != "text/plain" {
+		t.Errorf("Content-Type 被误删")
+	}
+}
+
+// TestTransportPoolSweep 测试 Dialer 缓存清空后失效路由的 Transport 被移除，仍在使用的路由重新创建
+func TestTransportPoolSweep(t *testing.T) {
+	var p transportPool
+	old, err := p.get("socks5://10.0.0.1:1080", false)
+	if err != nil {
+		t.Fatalf("创建 Transport 失败: %v", err)
+	}
+	if again, _ := p.get("socks5://10.0.0.1:1080", false); again != old {
+		t.Errorf("同一路由应复用同一个 Transport")
+	}
+	resetDialerCache()
+	if _, err := p.get("direct", false); err != nil {
+		t.Fatalf("创建 Transport 失败: %v", err)
+	}
+	if _, ok := p.transports["socks5://10.0.0.1:1080"]; ok || len(p.transports) != 1 {
+		t.Errorf("期望只保留 direct 路由的 Transport，实际 %d 个", len(p.transports))
+	}
+	if again, _ := p.get("socks5://10.0.0.1:1080", false); again == old {
+		t.Errorf("Dialer 缓存清空后应重新创建 Transport")
+	}
+}
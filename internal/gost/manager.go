@@ -3,19 +3,25 @@ package gost
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"sync"
 )
 
 // ========== 配置加载 ===========
 // UserProxyMap 存储用户名密码到下游代理地址的映射关系。
-// 键为 "username:password"，值为下游代理地址（如 http://host:port 或 socks5://host:port）。
+// 键为 "username:password"，值为路由：旧式下游代理地址（如 http://host:port、socks5://host:port）
+// 或结构化路由的 JSON（见 Route）。
 var UserProxyMap = make(map[string]string)
 
 // userProxyMapLock 用于保护 UserProxyMap 的并发读写。
 var userProxyMapLock sync.RWMutex
 
-// 代理端口常量，需与 main.go 启动端口保持一致
+// 代理默认端口常量，可通过配置文件 socks5_proxy_port/http_proxy_port 覆盖
 const (
 	SOCKS5ProxyPort = 1080 // SOCKS5 代理端口
 	HTTPProxyPort   = 1089 // HTTP 代理端口
@@ -31,25 +37,199 @@ type UserEntry struct {
 	Forward  string
 }
 
-// LoadUserProxyMap 从数据库加载用户代理映射到内存缓存
+// LoadUserProxyMap 从数据库加载用户代理映射到内存缓存。
+// route 列非空时按结构化路由加载，否则经注册节点 ip:SourcePort 转发；
+// 节点池中的 key 经池内各节点转发，优先于单个注册节点；配置文件中的静态路由优先级最高。
+// 同时加载各 key 的路由规则（register_key_rules），路由或规则无效的 key 被跳过，其余 key 照常加载，无效项以 *RouteError 合并返回。
 func LoadUserProxyMap(db *sql.DB) error {
 	userProxyMapLock.Lock()
 	defer userProxyMapLock.Unlock()
+	defer resetDialerCache()
 	UserProxyMap = make(map[string]string)
 
-	rows, err := db.Query("SELECT reg_key, ip_address FROM register_key_ip_map")
+	rows, err := db.Query("SELECT reg_key, ip_address, ip6_address, route FROM register_key_ip_map")
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
+	var errs []error
+	nodeIPv6 := make(map[string]string)
 	for rows.Next() {
 		var key, ip string
-		if err := rows.Scan(&key, &ip); err != nil {
+		var ip6, route sql.NullString
+		if err := rows.Scan(&key, &ip, &ip6, &route); err != nil {
 			return err
 		}
-		UserProxyMap[key+":"+key] = ip + ":" + strconv.Itoa(SourcePort)
+		if ip6.Valid && ip6.String != "" && ip6.String != ip {
+			nodeIPv6[ip] = ip6.String
+		}
+		r, err := userRoute(ip, route)
+		if err != nil {
+			errs = append(errs, &RouteError{Key: key, Err: err})
+			continue
+		}
+		UserProxyMap[key+":"+key] = r
 	}
-	return nil
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	setNodeIPv6Map(nodeIPv6)
+	pools, poolErrs, err := loadPools(db)
+	if err != nil {
+		return err
+	}
+	for key, r := range pools {
+		if err := poolErrs[key]; err != nil {
+			errs = append(errs, &RouteError{Key: key, Err: err})
+			continue
+		}
+		if err := r.Validate(); err != nil {
+			errs = append(errs, &RouteError{Key: key, Err: err})
+			continue
+		}
+		UserProxyMap[key+":"+key] = r.String()
+	}
+	for key, r := range staticRoutes {
+		UserProxyMap[key+":"+key] = r
+	}
+	ruleErrs, err := loadUserRules(db)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, ruleErrs...)
+	return errors.Join(errs...)
+}
+
+// loadUserRules 从数据库加载各 key 的路由规则并整体替换，规则无效的 key 被跳过并以 *RouteError 返回。
+func loadUserRules(db *sql.DB) ([]error, error) {
+	rows, err := db.Query("SELECT reg_key, rules FROM register_key_rules")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rulesLock.RLock()
+	named := namedRoutes
+	rulesLock.RUnlock()
+	sets := make(map[string]*RuleSet)
+	var errs []error
+	for rows.Next() {
+		var key string
+		var raw sql.NullString
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, err
+		}
+		if !raw.Valid {
+			continue
+		}
+		rules, err := DecodeRules(raw.String)
+		if err == nil && len(rules) > 0 {
+			sets[key], err = NewRuleSet(rules, named)
+		}
+		if err != nil {
+			errs = append(errs, &RouteError{Key: key, Err: err})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rulesLock.Lock()
+	userRules = sets
+	rulesLock.Unlock()
+	return errs, nil
+}
+
+// loadPools 从数据库加载各 key 的节点池，成员按加入顺序排列。
+// 成员标签无法解析的 key 记录在返回的第二个 map 中，由调用方跳过。
+func loadPools(db *sql.DB) (map[string]*Route, map[string]error, error) {
+	rows, err := db.Query(`SELECT p.reg_key, p.strategy, m.ip_address, m.labels
+		FROM register_key_pool p JOIN register_key_pool_member m ON m.reg_key = p.reg_key
+		ORDER BY p.reg_key, m.id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	pools := make(map[string]*Route)
+	poolErrs := make(map[string]error)
+	for rows.Next() {
+		var key, strategy, ip string
+		var labels sql.NullString
+		if err := rows.Scan(&key, &strategy, &ip, &labels); err != nil {
+			return nil, nil, err
+		}
+		if pools[key] == nil {
+			pools[key] = &Route{Strategy: strategy}
+		}
+		node := nodeRoute(ip)
+		if labels.Valid {
+			if err := json.Unmarshal([]byte(labels.String), &node.Labels); err != nil {
+				poolErrs[key] = fmt.Errorf("节点 %s 的标签无效: %w", ip, err)
+			}
+		}
+		pools[key].Pool = append(pools[key].Pool, node)
+	}
+	return pools, poolErrs, rows.Err()
+}
+
+// nodeRoute 返回经注册节点 ip:SourcePort 转发的路由。
+func nodeRoute(ip string) Route {
+	return Route{Node: true, Hops: []Hop{{Type: HopHTTP, Addr: net.JoinHostPort(ip, strconv.Itoa(SourcePort))}}}
+}
+
+// userRoute 返回数据库中一行注册记录对应的路由：route 列非空时校验并转为规范 JSON，否则为注册节点地址。
+func userRoute(ip string, route sql.NullString) (string, error) {
+	if !route.Valid || strings.TrimSpace(route.String) == "" {
+		return net.JoinHostPort(ip, strconv.Itoa(SourcePort)), nil
+	}
+	return canonicalRoute(route.String)
+}
+
+// canonicalRoute 解析并校验路由，返回其 JSON 形式。
+func canonicalRoute(s string) (string, error) {
+	r, err := DecodeRoute(s)
+	if err != nil {
+		return "", err
+	}
+	if err := r.Validate(); err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+// RouteError 表示某个注册 key 的路由定义无效，该 key 未被加载。
+type RouteError struct {
+	Key string
+	Err error
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("注册 key %s 的路由无效: %v", e.Key, e.Err)
+}
+
+func (e *RouteError) Unwrap() error { return e.Err }
+
+// staticRoutes 为配置文件中按注册 key 指定的路由（规范 JSON），每次加载数据库后覆盖到 UserProxyMap。
+var staticRoutes = make(map[string]string)
+
+// SetStaticRoutes 校验并设置配置文件中的静态路由，同时更新 UserProxyMap。
+// 无效路由被跳过，以 *RouteError 合并返回。
+func SetStaticRoutes(routes map[string]Route) error {
+	userProxyMapLock.Lock()
+	defer userProxyMapLock.Unlock()
+	defer resetDialerCache()
+	for key := range staticRoutes {
+		delete(UserProxyMap, key+":"+key)
+	}
+	staticRoutes = make(map[string]string, len(routes))
+	var errs []error
+	for key, r := range routes {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, &RouteError{Key: key, Err: err})
+			continue
+		}
+		staticRoutes[key] = r.String()
+		UserProxyMap[key+":"+key] = staticRoutes[key]
+	}
+	return errors.Join(errs...)
 }
 
 // RefreshUserProxyMapFromDB 是 LoadUserProxyMap 的别名，便于兼容旧调用
@@ -57,9 +237,56 @@ func RefreshUserProxyMapFromDB(db *sql.DB) error {
 	return LoadUserProxyMap(db)
 }
 
-// 增量添加新用户到内存缓存
+// 增量添加新用户到内存缓存，配置了静态路由的 key 保持静态路由
 func AddUserToProxyMap(key, ip string) {
 	userProxyMapLock.Lock()
 	defer userProxyMapLock.Unlock()
-	UserProxyMap[key+":"+key] = ip + ":" + strconv.Itoa(SourcePort)
+	if _, ok := staticRoutes[key]; ok {
+		return
+	}
+	old := UserProxyMap[key+":"+key]
+	UserProxyMap[key+":"+key] = net.JoinHostPort(ip, strconv.Itoa(SourcePort))
+	invalidateDialers(old)
+}
+
+// AddNodeToPool 将注册节点增量加入 key 的节点池，strategy 为该池在数据库中的策略，labels 为节点标签。
+// 节点已在池中时更新其标签；配置了静态路由的 key 保持静态路由。
+func AddNodeToPool(key, strategy, ip string, labels map[string]string) error {
+	userProxyMapLock.Lock()
+	defer userProxyMapLock.Unlock()
+	if _, ok := staticRoutes[key]; ok {
+		return nil
+	}
+	r := &Route{}
+	if cur, err := DecodeRoute(UserProxyMap[key+":"+key]); err == nil && len(cur.Pool) > 0 {
+		r = cur
+	}
+	r.Strategy = strategy
+	node := nodeRoute(ip)
+	node.Labels = labels
+	replaced := false
+	for i, m := range r.Pool {
+		if len(m.Hops) == 1 && m.Hops[0] == node.Hops[0] {
+			r.Pool[i], replaced = node, true
+			break
+		}
+	}
+	if !replaced {
+		r.Pool = append(r.Pool, node)
+	}
+	if err := r.Validate(); err != nil {
+		return &RouteError{Key: key, Err: err}
+	}
+	old := UserProxyMap[key+":"+key]
+	UserProxyMap[key+":"+key] = r.String()
+	invalidateDialers(old)
+	return nil
+}
+
+// HasUser 判断注册 key 是否存在于内存缓存中
+func HasUser(key string) bool {
+	userProxyMapLock.RLock()
+	defer userProxyMapLock.RUnlock()
+	_, ok := UserProxyMap[key+":"+key]
+	return ok
 }
@@ -0,0 +1,138 @@
+package gost
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// MixedProxyServer 实现单端口混合协议代理服务。
+// 预读每个连接的首字节：0x04/0x05 交给 SOCKS4/SOCKS5 处理，其余按 HTTP 代理处理，
+// 两种协议共用同一套认证与路由逻辑。
+type MixedProxyServer struct {
+	addr  string
+	socks *SOCKS5Server
+	http  *HTTPProxyServer
+	state serverState
+}
+
+// NewMixedProxyServer 创建一个新的 MixedProxyServer 实例。
+// 参数 addr 为监听地址（如 ":1090"），opts 同时作用于 SOCKS 与 HTTP 两侧，返回 MixedProxyServer 指针。
+func NewMixedProxyServer(addr string, opts ...Option) *MixedProxyServer {
+	return &MixedProxyServer{
+		addr:  addr,
+		socks: NewSOCKS5Server(addr, opts...),
+		http:  NewHTTPProxyServer(addr, opts...),
+	}
+}
+
+// Start 启动混合协议代理服务器，监听指定地址并按协议分发客户端连接。
+// 返回 error 表示启动或运行过程中遇到的错误。
+func (m *MixedProxyServer) Start() error {
+	listener, err := net.Listen("tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("混合协议代理已启动，监听地址: %s", m.addr)
+	return m.Serve(context.Background(), listener)
+}
+
+// Serve 在 listener 上接受连接并按协议分发，直到 ctx 取消或调用 Shutdown。
+// 服务关闭后返回 ErrServerClosed。
+func (m *MixedProxyServer) Serve(ctx context.Context, listener net.Listener) error {
+	// HTTP 连接经内部监听器交给 http.Server，复用其请求解析与长连接处理
+	httpListener := newChanListener(listener.Addr())
+	defer httpListener.Close()
+	go m.http.server.Serve(httpListener)
+	return m.state.serve(ctx, m.socks.opts.wrapProxyProto(listener), func(conn net.Conn) {
+		m.dispatch(conn, httpListener)
+	})
+}
+
+// Shutdown 优雅关闭混合协议代理服务器：停止接收新连接，并行排空 SOCKS 与 HTTP 会话；
+// ctx 到期时强制关闭剩余会话并返回 ctx.Err()。
+func (m *MixedProxyServer) Shutdown(ctx context.Context) error {
+	m.state.drain(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.http.Shutdown(ctx)
+	}()
+	err := m.socks.Shutdown(ctx)
+	if herr := <-errc; err == nil {
+		err = herr
+	}
+	return err
+}
+
+// ActiveSessions 返回当前活跃会话数（SOCKS 会话与 HTTP 隧道之和）。
+func (m *MixedProxyServer) ActiveSessions() int {
+	return m.socks.ActiveSessions() + m.http.ActiveSessions()
+}
+
+// dispatch 预读连接首字节并分发到对应的协议处理逻辑。
+func (m *MixedProxyServer) dispatch(conn net.Conn, httpListener *chanListener) {
+	// 预读首字节同样受握手期限约束，静默连接不会一直占用 goroutine
+	if d := m.socks.opts.handshakeTimeout; d > 0 {
+		conn.SetReadDeadline(time.Now().Add(d))
+	}
+	bc := newBufferedConn(conn)
+	head, err := bc.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	switch head[0] {
+	case SOCKS4Version, SOCKS5Version:
+		m.socks.handleConnection(bc)
+	default:
+		if err := httpListener.push(bc); err != nil {
+			conn.Close()
+		}
+	}
+}
+
+// chanListener 是基于 channel 的 net.Listener，用于把已接受的连接交给 http.Server。
+type chanListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newChanListener(addr net.Addr) *chanListener {
+	return &chanListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// push 投递一个连接，监听器已关闭时返回错误。
+func (l *chanListener) push(conn net.Conn) error {
+	select {
+	case l.conns <- conn:
+		return nil
+	case <-l.done:
+		return net.ErrClosed
+	}
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *chanListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr {
+	return l.addr
+}
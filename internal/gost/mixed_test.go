@@ -0,0 +1,97 @@
+package gost
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startEchoServer 启动 TCP 回显服务，返回监听地址
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestMixedProxyServer 测试单端口上 SOCKS5 与 HTTP CONNECT 的协议分发
+func TestMixedProxyServer(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["mixed:mixed"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go NewMixedProxyServer(":0").Serve(context.Background(), listener)
+	defer listener.Close()
+	mixedAddr := listener.Addr().String()
+
+	expectEcho := func(t *testing.T, conn net.Conn) {
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+		}
+	}
+
+	t.Run("SOCKS5", func(t *testing.T) {
+		conn, err := net.Dial("tcp", mixedAddr)
+		if err != nil {
+			t.Fatalf("连接混合端口失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		socks5TestLogin(t, conn, "mixed", "mixed")
+		dst, _ := encodeSOCKS5Addr(echoAddr)
+		conn.Write(append([]byte{0x05, ConnectCmd, 0x00}, dst...))
+		if rep, _, err := readSOCKS5Reply(conn); err != nil || rep != RepSucceeded {
+			t.Fatalf("SOCKS5 CONNECT 失败: rep=%d, err=%v", rep, err)
+		}
+		expectEcho(t, conn)
+	})
+
+	t.Run("HTTP CONNECT", func(t *testing.T) {
+		conn, err := net.Dial("tcp", mixedAddr)
+		if err != nil {
+			t.Fatalf("连接混合端口失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		req := "CONNECT " + echoAddr + " HTTP/1.1\r\nHost: " + echoAddr + "\r\n" +
+			"Proxy-Authorization: Basic " + base64EncodeString("mixed:mixed") + "\r\n\r\n"
+		conn.Write([]byte(req))
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("HTTP CONNECT 失败: resp=%v, err=%v", resp, err)
+		}
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package gost
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// 代理会话默认超时
+const (
+	DefaultHandshakeTimeout = 30 * time.Second // 握手与认证阶段的默认期限
+	DefaultIdleTimeout      = 30 * time.Minute // 隧道双向无数据的默认最长时间
+)
+
+// Option 用于配置 SOCKS5Server、HTTPProxyServer 与 MixedProxyServer 的可选参数。
+type Option func(*serverOptions)
+
+// serverOptions 为代理服务的可选参数集合。
+type serverOptions struct {
+	handshakeTimeout time.Duration
+	idleTimeout      time.Duration
+	tlsConfig        *tls.Config
+	anonymous        *AnonymousPolicy
+	proxyProtoFrom   []*net.IPNet // 接受 PROXY 头部的可信来源网段
+	proxyHeaderOut   int          // 向下游节点发送的 PROXY 头部版本，0 表示不发送
+}
+
+// newServerOptions 返回应用了 opts 的参数集合，未设置的项使用默认值。
+func newServerOptions(opts []Option) serverOptions {
+	o := serverOptions{
+		handshakeTimeout: DefaultHandshakeTimeout,
+		idleTimeout:      DefaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithHandshakeTimeout 设置客户端完成握手、认证并发出请求的期限，0 表示不限制。
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(o *serverOptions) {
+		o.handshakeTimeout = d
+	}
+}
+
+// WithIdleTimeout 设置隧道双向均无数据时的最长保持时间，超时后关闭隧道，0 表示不限制。
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *serverOptions) {
+		o.idleTimeout = d
+	}
+}
+
+// WithTLSConfig 使 HTTPProxyServer 以 TLS 方式监听（https:// 代理），客户端与代理之间的认证信息经加密传输。
+// cfg 通常由 NewServerTLSConfig 创建。
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *serverOptions) {
+		o.tlsConfig = cfg
+	}
+}
+
+// WithAnonymousPolicy 设置未提供认证信息的客户端的路由策略，未设置时拒绝匿名访问。
+func WithAnonymousPolicy(p *AnonymousPolicy) Option {
+	return func(o *serverOptions) {
+		o.anonymous = p
+	}
+}
+
+// WithProxyProtocol 在监听器上启用 PROXY protocol v1/v2 解析，仅信任来自 trusted 网段的头部。
+// 可信来源的连接必须携带头部，其余来源的连接按普通连接处理。
+func WithProxyProtocol(trusted []*net.IPNet) Option {
+	return func(o *serverOptions) {
+		o.proxyProtoFrom = trusted
+	}
+}
+
+// WithDownstreamProxyHeader 在连接下游节点后先发送 PROXY protocol 头部（ProxyProtocolV1 或 ProxyProtocolV2），
+// 使节点侧代理获得真实客户端地址。0 表示不发送。
+func WithDownstreamProxyHeader(version int) Option {
+	return func(o *serverOptions) {
+		o.proxyHeaderOut = version
+	}
+}
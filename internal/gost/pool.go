@@ -0,0 +1,283 @@
+package gost
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 节点池的负载均衡策略
+const (
+	StrategyRoundRobin     = "round_robin"     // 轮询
+	StrategyRandom         = "random"          // 随机
+	StrategyLeastActive    = "least_active"    // 活跃连接数最少
+	StrategyConsistentHash = "consistent_hash" // 按目标主机一致性哈希，同一主机固定经同一节点
+)
+
+// poolHashReplicas 为一致性哈希环上每个成员的虚拟节点数。
+const poolHashReplicas = 100
+
+// PoolMember 为节点池中的一个成员。
+type PoolMember struct {
+	Name   string            // 用于日志与活跃连接计数的名称，不含凭据
+	Labels map[string]string // 节点标签（如 country、tag），供用户名参数筛选
+	Dialer Dialer
+}
+
+// PoolDialer 按负载均衡策略在多个成员间分配连接，如同一项目下注册的所有 tailnet 节点。
+// ctx 中携带用户名参数（见 ParseUsername）时，先按标签筛选成员，同一会话 ID 在 StickyTTL 内固定经同一成员。
+// 选中的成员因自身故障失败时依次尝试其后的成员；目标地址拒绝或不可达时直接返回。
+type PoolDialer struct {
+	Members  []PoolMember
+	Strategy string // 为空时使用 StrategyRoundRobin
+
+	next     uint32   // 轮询计数
+	ring     []uint32 // 一致性哈希环上的虚拟节点哈希值，升序
+	ringNode []int    // 与 ring 对应的成员下标
+	ringOnce sync.Once
+}
+
+// DialContext 按策略选择成员并建连，返回的连接关闭前计入该成员的活跃连接数。
+func (d *PoolDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(d.Members) == 0 {
+		return nil, fmt.Errorf("节点池为空")
+	}
+	params := userParamsFromContext(ctx)
+	cands := d.filter(params.Labels)
+	if len(cands) == 0 {
+		return nil, &DialError{Addr: addr, Kind: ErrNodeUnreachable, Err: fmt.Errorf("节点池中没有匹配 %v 的节点", params.Labels)}
+	}
+	sessionKey := ""
+	if params.Session != "" {
+		sessionKey = params.Key + "|" + params.Session
+	}
+	start := -1
+	if sessionKey != "" {
+		if name, ok := stickySessions.get(sessionKey); ok {
+			for k, i := range cands {
+				if d.Members[i].Name == name {
+					start = k
+					break
+				}
+			}
+		}
+	}
+	if start < 0 {
+		start = d.pick(addr, cands)
+	}
+	n := len(cands)
+	var lastErr error
+	for k := 0; k < n; k++ {
+		m := d.Members[cands[(start+k)%n]]
+		conn, err := m.Dialer.DialContext(ctx, network, addr)
+		if err == nil {
+			if sessionKey != "" && stickySessions.pin(sessionKey, m.Name, StickyTTL) {
+				log.Printf("Pool: session %s pinned to %s for %s", params.Session, m.Name, StickyTTL)
+			}
+			return poolActive.track(m.Name, conn), nil
+		}
+		if ctx.Err() != nil || isTargetError(err) {
+			return nil, err
+		}
+		log.Printf("Pool: member %s failed: %v", m.Name, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// filter 返回标签匹配 labels 的成员下标，labels 为空时返回全部成员。
+// 成员标签值可用逗号分隔多个取值（如 tag: "gpu,residential"），按不区分大小写匹配其一。
+func (d *PoolDialer) filter(labels map[string]string) []int {
+	cands := make([]int, 0, len(d.Members))
+	for i, m := range d.Members {
+		if matchLabels(m.Labels, labels) {
+			cands = append(cands, i)
+		}
+	}
+	return cands
+}
+
+func matchLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		matched := false
+		for _, item := range strings.Split(have[k], ",") {
+			if strings.EqualFold(strings.TrimSpace(item), v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// pick 按策略返回 cands 中首先尝试的位置。
+func (d *PoolDialer) pick(addr string, cands []int) int {
+	n := len(cands)
+	switch d.Strategy {
+	case StrategyRandom:
+		return rand.Intn(n)
+	case StrategyLeastActive:
+		best, bestActive := 0, int64(-1)
+		for k, i := range cands {
+			if a := poolActive.count(d.Members[i].Name); bestActive < 0 || a < bestActive {
+				best, bestActive = k, a
+			}
+		}
+		return best
+	case StrategyConsistentHash:
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		return d.lookup(host, cands)
+	default:
+		return int((atomic.AddUint32(&d.next, 1) - 1) % uint32(n))
+	}
+}
+
+// lookup 在一致性哈希环上查找 key 对应的、属于 cands 的第一个成员，返回其在 cands 中的位置。
+// 成员增减时只有少量主机改变所经节点。
+func (d *PoolDialer) lookup(key string, cands []int) int {
+	d.ringOnce.Do(d.buildRing)
+	pos := make(map[int]int, len(cands))
+	for k, i := range cands {
+		pos[i] = k
+	}
+	h := hash32(key)
+	start := sort.Search(len(d.ring), func(i int) bool { return d.ring[i] >= h })
+	for j := 0; j < len(d.ring); j++ {
+		if k, ok := pos[d.ringNode[(start+j)%len(d.ring)]]; ok {
+			return k
+		}
+	}
+	return 0
+}
+
+func (d *PoolDialer) buildRing() {
+	type vnode struct {
+		hash uint32
+		node int
+	}
+	vnodes := make([]vnode, 0, len(d.Members)*poolHashReplicas)
+	for i, m := range d.Members {
+		for r := 0; r < poolHashReplicas; r++ {
+			vnodes = append(vnodes, vnode{hash32(m.Name + "#" + strconv.Itoa(r)), i})
+		}
+	}
+	sort.Slice(vnodes, func(i, j int) bool { return vnodes[i].hash < vnodes[j].hash })
+	d.ring = make([]uint32, len(vnodes))
+	d.ringNode = make([]int, len(vnodes))
+	for i, v := range vnodes {
+		d.ring[i], d.ringNode[i] = v.hash, v.node
+	}
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// poolActive 按成员名称统计活跃连接数，不随 Dialer 缓存清空而丢失。
+var poolActive = &activeCounter{counts: make(map[string]*int64)}
+
+type activeCounter struct {
+	mu     sync.Mutex
+	counts map[string]*int64
+}
+
+func (c *activeCounter) counter(name string) *int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.counts[name]
+	if !ok {
+		p = new(int64)
+		c.counts[name] = p
+	}
+	return p
+}
+
+func (c *activeCounter) count(name string) int64 {
+	return atomic.LoadInt64(c.counter(name))
+}
+
+// track 将 conn 计入 name 的活跃连接数，连接关闭时扣减。
+func (c *activeCounter) track(name string, conn net.Conn) net.Conn {
+	p := c.counter(name)
+	atomic.AddInt64(p, 1)
+	return &trackedConn{Conn: conn, release: func() { atomic.AddInt64(p, -1) }}
+}
+
+// trackedConn 在首次 Close 时执行 release。
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// StickyTTL 为同一会话 ID 固定经同一节点的时长，可在启动时通过配置文件 sticky_ttl 覆盖。
+var StickyTTL = 10 * time.Minute
+
+// stickySessions 记录会话固定的节点，不随 Dialer 缓存清空而丢失。
+var stickySessions = &stickyTable{entries: make(map[string]stickyEntry)}
+
+type stickyEntry struct {
+	member  string
+	expires time.Time
+}
+
+type stickyTable struct {
+	mu        sync.Mutex
+	entries   map[string]stickyEntry
+	nextSweep time.Time
+}
+
+// get 返回会话固定的节点，已过期时返回 false。
+func (t *stickyTable) get(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.member, true
+}
+
+// pin 将会话固定到 member，有效期从首次固定起计算；会话改经其他节点时重新计时。
+// 返回是否新建或变更了固定关系。
+func (t *stickyTable) pin(key, member string, ttl time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if e, ok := t.entries[key]; ok && e.member == member && now.Before(e.expires) {
+		return false
+	}
+	t.entries[key] = stickyEntry{member: member, expires: now.Add(ttl)}
+	// 定期清理过期会话
+	if now.After(t.nextSweep) {
+		for k, e := range t.entries {
+			if now.After(e.expires) {
+				delete(t.entries, k)
+			}
+		}
+		t.nextSweep = now.Add(time.Minute)
+	}
+	return true
+}
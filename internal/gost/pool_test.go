@@ -0,0 +1,173 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// stubDialer 记录建连次数，返回内存管道连接或固定错误
+type stubDialer struct {
+	hits int32
+	err  error
+}
+
+func (d *stubDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	atomic.AddInt32(&d.hits, 1)
+	if d.err != nil {
+		return nil, d.err
+	}
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return c1, nil
+}
+
+// newStubPool 创建成员为 stubDialer 的节点池，成员名称带 prefix 以隔离活跃连接计数
+func newStubPool(prefix, strategy string, n int) (*PoolDialer, []*stubDialer) {
+	d := &PoolDialer{Strategy: strategy}
+	stubs := make([]*stubDialer, n)
+	for i := range stubs {
+		stubs[i] = &stubDialer{}
+		d.Members = append(d.Members, PoolMember{Name: fmt.Sprintf("%s-%d", prefix, i), Dialer: stubs[i]})
+	}
+	return d, stubs
+}
+
+// TestPoolDialerStrategies 测试节点池各负载均衡策略
+func TestPoolDialerStrategies(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("轮询", func(t *testing.T) {
+		d, stubs := newStubPool("rr", StrategyRoundRobin, 3)
+		for i := 0; i < 6; i++ {
+			conn, err := d.DialContext(ctx, "tcp", "example.com:80")
+			if err != nil {
+				t.Fatalf("建连失败: %v", err)
+			}
+			conn.Close()
+		}
+		for i, s := range stubs {
+			if s.hits != 2 {
+				t.Errorf("成员 %d 期望 2 次，实际 %d 次", i, s.hits)
+			}
+		}
+	})
+
+	t.Run("随机", func(t *testing.T) {
+		d, stubs := newStubPool("random", StrategyRandom, 2)
+		for i := 0; i < 100; i++ {
+			conn, err := d.DialContext(ctx, "tcp", "example.com:80")
+			if err != nil {
+				t.Fatalf("建连失败: %v", err)
+			}
+			conn.Close()
+		}
+		if stubs[0].hits == 0 || stubs[1].hits == 0 {
+			t.Errorf("100 次随机选择应覆盖所有成员，实际 %d/%d", stubs[0].hits, stubs[1].hits)
+		}
+	})
+
+	t.Run("最少活跃连接", func(t *testing.T) {
+		d, stubs := newStubPool("least", StrategyLeastActive, 2)
+		held, err := d.DialContext(ctx, "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("建连失败: %v", err)
+		}
+		conn, _ := d.DialContext(ctx, "tcp", "example.com:80")
+		if stubs[1].hits != 1 {
+			t.Errorf("成员 0 有活跃连接时应选择成员 1，实际命中 %d/%d", stubs[0].hits, stubs[1].hits)
+		}
+		held.Close()
+		held.Close() // 重复关闭不应重复扣减
+		if got := poolActive.count("least-0"); got != 0 {
+			t.Errorf("连接关闭后活跃数应为 0，实际 %d", got)
+		}
+		conn2, _ := d.DialContext(ctx, "tcp", "example.com:80")
+		if stubs[0].hits != 2 {
+			t.Errorf("成员 0 连接关闭后应重新被选择")
+		}
+		conn.Close()
+		conn2.Close()
+	})
+
+	t.Run("一致性哈希", func(t *testing.T) {
+		d, stubs := newStubPool("hash", StrategyConsistentHash, 3)
+		all := []int{0, 1, 2}
+		first := d.pick("api.example.com:443", all)
+		for _, addr := range []string{"api.example.com:443", "api.example.com:80", "api.example.com:8443"} {
+			if got := d.pick(addr, all); got != first {
+				t.Errorf("同一主机应固定经同一成员，%s 期望 %d 实际 %d", addr, first, got)
+			}
+		}
+		for i := 0; i < 60; i++ {
+			conn, err := d.DialContext(ctx, "tcp", fmt.Sprintf("host%d.example.com:443", i))
+			if err != nil {
+				t.Fatalf("建连失败: %v", err)
+			}
+			conn.Close()
+		}
+		for i, s := range stubs {
+			if s.hits == 0 {
+				t.Errorf("60 个不同主机应分布到所有成员，成员 %d 未被使用", i)
+			}
+		}
+	})
+}
+
+// TestPoolDialerMemberFailure 测试选中成员故障时依次尝试其后的成员，目标地址错误时直接返回
+func TestPoolDialerMemberFailure(t *testing.T) {
+	d, stubs := newStubPool("fail", StrategyRoundRobin, 2)
+	stubs[0].err = newHopError("100.64.0.1:8939", syscall.ECONNREFUSED)
+	conn, err := d.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("期望由成员 1 接管: %v", err)
+	}
+	conn.Close()
+	if stubs[1].hits != 1 {
+		t.Errorf("成员 0 故障时应尝试成员 1")
+	}
+
+	d, stubs = newStubPool("target", StrategyRoundRobin, 2)
+	stubs[0].err = newTargetError("example.com:80", syscall.ECONNREFUSED)
+	if _, err := d.DialContext(context.Background(), "tcp", "example.com:80"); !errors.Is(err, ErrConnectionRefused) {
+		t.Errorf("期望 ErrConnectionRefused，实际 %v", err)
+	}
+	if stubs[1].hits != 0 {
+		t.Errorf("目标地址的失败不应尝试其他成员")
+	}
+}
+
+// TestAddNodeToPool 测试注册节点增量加入节点池
+func TestAddNodeToPool(t *testing.T) {
+	for _, ip := range []string{"100.64.1.1", "100.64.1.2", "100.64.1.1"} {
+		if err := AddNodeToPool("project-x", StrategyLeastActive, ip, nil); err != nil {
+			t.Fatalf("加入节点池失败: %v", err)
+		}
+	}
+	d, err := DialerForUser("project-x", "project-x")
+	if err != nil {
+		t.Fatalf("节点池未加载: %v", err)
+	}
+	pool, ok := d.(*PoolDialer)
+	if !ok || len(pool.Members) != 2 || pool.Strategy != StrategyLeastActive {
+		t.Fatalf("期望 2 个成员的 least_active 节点池，实际 %#v", d)
+	}
+	if pool.Members[1].Name != "http://100.64.1.2:8939" {
+		t.Errorf("成员名称不符合预期: %s", pool.Members[1].Name)
+	}
+	// 重复加入时更新节点标签
+	if err := AddNodeToPool("project-x", StrategyLeastActive, "100.64.1.2", map[string]string{"country": "us"}); err != nil {
+		t.Fatalf("更新节点标签失败: %v", err)
+	}
+	d, _ = DialerForUser("project-x", "project-x")
+	if pool := d.(*PoolDialer); len(pool.Members) != 2 || pool.Members[1].Labels["country"] != "us" {
+		t.Errorf("期望更新成员 1 的标签，实际 %+v", pool.Members)
+	}
+	if err := AddNodeToPool("project-y", "weighted", "100.64.1.3", nil); err == nil {
+		t.Errorf("不支持的策略应返回错误")
+	}
+}
@@ -0,0 +1,295 @@
+package gost
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol 版本
+const (
+	ProxyProtocolV1 = 1 // 文本格式
+	ProxyProtocolV2 = 2 // 二进制格式
+)
+
+// proxyProtoV2Sig 为 PROXY protocol v2 头部的 12 字节签名。
+var proxyProtoV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoV1MaxLen 为 v1 头部（含 CRLF）的最大长度。
+const proxyProtoV1MaxLen = 107
+
+// defaultProxyHeaderTimeout 为未设置握手期限时读取 PROXY 头部的期限。
+const defaultProxyHeaderTimeout = 10 * time.Second
+
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// ParseCIDRs 解析 CIDR 列表，用于配置可信来源网段。
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// wrapProxyProto 在配置了可信来源网段时为监听器启用 PROXY protocol 解析。
+func (o serverOptions) wrapProxyProto(ln net.Listener) net.Listener {
+	if len(o.proxyProtoFrom) == 0 {
+		return ln
+	}
+	return newProxyProtoListener(ln, o.proxyProtoFrom, o.handshakeTimeout)
+}
+
+// proxyProtoListener 包装监听器：来自可信网段的连接必须以 PROXY protocol v1/v2 头部开头，
+// 连接的 RemoteAddr/LocalAddr 替换为头部中声明的真实地址；其他来源的连接原样返回。
+type proxyProtoListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func newProxyProtoListener(ln net.Listener, trusted []*net.IPNet, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyProtoListener{Listener: ln, trusted: trusted, timeout: timeout}
+}
+
+// Accept 不在此处读取头部，避免慢速客户端阻塞接收循环；头部在连接首次使用时解析。
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !ipInNetworks(remoteIP(conn), l.trusted) {
+		return conn, nil
+	}
+	return &proxyProtoConn{Conn: conn, r: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// ipInNetworks 判断 ip 是否属于 networks 中任一网段。
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtoConn 在首次 Read、取地址或设置期限时解析 PROXY 头部。
+type proxyProtoConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once     sync.Once
+	src, dst net.Addr
+	err      error
+}
+
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.src, c.dst, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("PROXY protocol header from %s rejected: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	c.init()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyProtoConn) SetDeadline(t time.Time) error {
+	c.init()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyProtoConn) SetReadDeadline(t time.Time) error {
+	c.init()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readProxyHeader 读取 v1 或 v2 头部，返回声明的源地址与目的地址；
+// LOCAL 命令或 UNKNOWN 协议族时地址为 nil，表示沿用连接本身的地址。
+func readProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	head, err := r.Peek(len(proxyProtoV2Sig))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(head, proxyProtoV2Sig) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(head, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, nil, errProxyHeader
+}
+
+// readProxyHeaderV1 解析文本头部：PROXY TCP4|TCP6|UNKNOWN SRC DST SPORT DPORT\r\n。
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyProtoV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header too long", errProxyHeader)
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", errProxyHeader, line)
+	}
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("%w: bad address %s:%s", errProxyHeader, host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+// readProxyHeaderV2 解析二进制头部：SIG(12) + VER_CMD + FAM + LEN(2) + ADDR [+ TLV]，TLV 被忽略。
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	if verCmd>>4 != 0x2 {
+		return nil, nil, fmt.Errorf("%w: version %d", errProxyHeader, verCmd>>4)
+	}
+	switch verCmd & 0x0F {
+	case 0x0:
+		// LOCAL：负载均衡器自身的健康检查等，沿用连接地址
+		return nil, nil, nil
+	case 0x1:
+	default:
+		return nil, nil, fmt.Errorf("%w: command %d", errProxyHeader, verCmd&0x0F)
+	}
+	switch fam {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, nil, fmt.Errorf("%w: short IPv4 address block", errProxyHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, nil, fmt.Errorf("%w: short IPv6 address block", errProxyHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}, nil
+	default:
+		// UNSPEC、UDP、UNIX 等协议族不携带可用的 TCP 地址
+		return nil, nil, nil
+	}
+}
+
+// buildProxyHeader 生成发往下游节点的 PROXY 头部，src 为真实客户端地址，dst 为客户端连接的代理地址。
+// 地址不是 TCP 地址或协议族不一致时生成 UNKNOWN（v1）或 LOCAL（v2）头部。
+func buildProxyHeader(version int, src, dst net.Addr) []byte {
+	s, _ := src.(*net.TCPAddr)
+	d, _ := dst.(*net.TCPAddr)
+	v4 := s != nil && d != nil && s.IP.To4() != nil && d.IP.To4() != nil
+	v6 := s != nil && d != nil && !v4 && s.IP.To4() == nil && d.IP.To4() == nil
+	if version == ProxyProtocolV1 {
+		switch {
+		case v4:
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", s.IP, d.IP, s.Port, d.Port))
+		case v6:
+			return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", s.IP, d.IP, s.Port, d.Port))
+		default:
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+	}
+	hdr := append([]byte{}, proxyProtoV2Sig...)
+	var block []byte
+	switch {
+	case v4:
+		hdr = append(hdr, 0x21, 0x11)
+		block = append(append(block, s.IP.To4()...), d.IP.To4()...)
+	case v6:
+		hdr = append(hdr, 0x21, 0x21)
+		block = append(append(block, s.IP.To16()...), d.IP.To16()...)
+	default:
+		return append(hdr, 0x20, 0x00, 0x00, 0x00)
+	}
+	block = binary.BigEndian.AppendUint16(block, uint16(s.Port))
+	block = binary.BigEndian.AppendUint16(block, uint16(d.Port))
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(block)))
+	return append(hdr, block...)
+}
+
+// contextWithProxyHeader 在启用下游 PROXY 头部时，返回携带客户端连接头部的 ctx；未启用时原样返回。
+func contextWithProxyHeader(ctx context.Context, version int, src, dst net.Addr) context.Context {
+	if version != ProxyProtocolV1 && version != ProxyProtocolV2 {
+		return ctx
+	}
+	return withProxyHeader(ctx, buildProxyHeader(version, src, dst))
+}
@@ -0,0 +1,157 @@
+package gost
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestReadProxyHeader 测试 PROXY protocol v1/v2 头部解析
+func TestReadProxyHeader(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51000}
+	dst4 := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 1080}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1080}
+
+	tests := []struct {
+		name    string
+		header  []byte
+		wantSrc string
+		wantErr bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 1080\r\n"), "203.0.113.7:51000", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51000 1080\r\n"), "[2001:db8::7]:51000", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v2 TCP4", buildProxyHeader(ProxyProtocolV2, src4, dst4), "203.0.113.7:51000", false},
+		{"v2 TCP6", buildProxyHeader(ProxyProtocolV2, src6, dst6), "[2001:db8::7]:51000", false},
+		{"v2 LOCAL", buildProxyHeader(ProxyProtocolV2, nil, nil), "", false},
+		{"v1 往返", buildProxyHeader(ProxyProtocolV1, src4, dst4), "203.0.113.7:51000", false},
+		{"缺少头部", []byte("GET / HTTP/1.1\r\n\r\n"), "", true},
+		{"v1 端口无效", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 99999 1080\r\n"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 头部之后的数据不应被消费
+			r := bufio.NewReader(bytes.NewReader(append(tt.header, "payload"...)))
+			src, _, err := readProxyHeader(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望出错=%v，实际 err=%v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := ""
+			if src != nil {
+				got = src.String()
+			}
+			if got != tt.wantSrc {
+				t.Errorf("期望源地址 %q，实际 %q", tt.wantSrc, got)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("头部后的数据被破坏: %q", rest)
+			}
+		})
+	}
+}
+
+// TestSOCKS5ProxyProtocol 测试可信来源的 PROXY 头部生效，并按真实客户端地址应用匿名策略
+func TestSOCKS5ProxyProtocol(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	trusted, _ := ParseCIDRs([]string{"127.0.0.0/8"})
+	policy, _ := NewAnonymousPolicy("", []AnonymousRule{{CIDR: "203.0.113.0/24", Route: "socks5://" + downstream}})
+	serverAddr := startSOCKS5ServerWithOptions(t, WithProxyProtocol(trusted), WithAnonymousPolicy(policy))
+
+	tests := []struct {
+		name   string
+		header string
+		want   byte
+	}{
+		{"真实客户端在允许网段", "PROXY TCP4 203.0.113.7 127.0.0.1 51000 1080\r\n", NoAuth},
+		{"真实客户端不在允许网段", "PROXY TCP4 198.51.100.7 127.0.0.1 51000 1080\r\n", 0xFF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", serverAddr)
+			if err != nil {
+				t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write([]byte(tt.header))
+			conn.Write([]byte{0x05, 0x01, NoAuth})
+			resp := make([]byte, 2)
+			if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != tt.want {
+				t.Fatalf("期望方法 %#x，实际 %v, err=%v", tt.want, resp, err)
+			}
+			if tt.want != NoAuth {
+				return
+			}
+			addr, _ := encodeSOCKS5Addr(echoAddr)
+			conn.Write(append([]byte{0x05, ConnectCmd, 0x00}, addr...))
+			if rep, _, err := readSOCKS5Reply(conn); err != nil || rep != RepSucceeded {
+				t.Fatalf("CONNECT 失败: rep=%d, err=%v", rep, err)
+			}
+			t.Logf("✅ 按 PROXY 头部中的真实地址完成路由")
+		})
+	}
+}
+
+// TestDownstreamProxyHeader 测试连接下游节点时发送 PROXY 头部
+func TestDownstreamProxyHeader(t *testing.T) {
+	// 下游节点：读取 PROXY 头部后按 HTTP CONNECT 代理应答
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	seen := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		src, _, err := readProxyHeader(br)
+		if err != nil {
+			seen <- "error: " + err.Error()
+			return
+		}
+		seen <- src.String()
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	}()
+
+	userProxyMapLock.Lock()
+	UserProxyMap["ppout:ppout"] = ln.Addr().String()
+	userProxyMapLock.Unlock()
+	serverAddr := startSOCKS5ServerWithOptions(t, WithDownstreamProxyHeader(ProxyProtocolV1))
+
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	socks5TestLogin(t, conn, "ppout", "ppout")
+	addr, _ := encodeSOCKS5Addr("example.com:80")
+	conn.Write(append([]byte{0x05, ConnectCmd, 0x00}, addr...))
+	if rep, _, err := readSOCKS5Reply(conn); err != nil || rep != RepSucceeded {
+		t.Fatalf("CONNECT 失败: rep=%d, err=%v", rep, err)
+	}
+	select {
+	case got := <-seen:
+		if got != conn.LocalAddr().String() {
+			t.Errorf("期望下游看到客户端地址 %s，实际 %s", conn.LocalAddr(), got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("下游未收到连接")
+	}
+}
@@ -0,0 +1,62 @@
+package gost
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// relayBufferSize 为启用空闲超时时每个方向的转发缓冲区大小。
+const relayBufferSize = 32 * 1024
+
+// relayConns 实现两个连接之间的双向数据转发，任一方向结束时关闭两端。
+// idle > 0 时在转发循环中检测空闲：两个方向均超过 idle 没有数据即关闭隧道；
+// 只要其中一个方向仍有数据，隧道就保持打开。
+func relayConns(conn1, conn2 net.Conn, idle time.Duration) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	pipe := func(dst, src net.Conn) {
+		if idle <= 0 {
+			io.Copy(dst, src)
+			return
+		}
+		buf := make([]byte, relayBufferSize)
+		for {
+			src.SetReadDeadline(time.Now().Add(idle))
+			n, err := src.Read(buf)
+			if n > 0 {
+				lastActive.Store(time.Now().UnixNano())
+				dst.SetWriteDeadline(time.Now().Add(idle))
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				// 本方向读超时但另一方向仍活跃时继续等待
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() &&
+					time.Since(time.Unix(0, lastActive.Load())) < idle {
+					continue
+				}
+				return
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// 启动两个 goroutine 实现双向转发
+	go func() {
+		defer wg.Done()
+		pipe(conn1, conn2) // 下游 -> 客户端
+		conn1.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(conn2, conn1) // 客户端 -> 下游
+		conn2.Close()
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,574 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 目标域名的解析方式
+const (
+	DNSRemote  = "remote"  // 域名原样交给下游节点解析，直连时使用系统解析器（默认）
+	DNSLocal   = "local"   // 经 servers 中的 DNS 服务器在本地解析
+	DNSDoH     = "doh"     // 经 DNS-over-HTTPS（RFC 8484）在本地解析
+	DNSTailnet = "tailnet" // 经用户的路由（tailnet 节点）访问 servers 中的 DNS 服务器解析
+)
+
+// DefaultDNSCacheTTL 为解析结果的默认缓存时长上限。
+const DefaultDNSCacheTTL = time.Minute
+
+// DNSConfig 为目标域名的解析配置。
+// mode 不是 remote 时，直连路由与经 SOCKS5 下游发送的 CONNECT 请求均使用解析后的 IP；
+// hosts 覆盖在任何 mode 下均生效，用户的 hosts 优先于全局 hosts。
+type DNSConfig struct {
+	Mode      string                       `yaml:"mode"`       // remote（默认）、local、doh 或 tailnet
+	Servers   []string                     `yaml:"servers"`    // local 与 tailnet 使用的 DNS 服务器（IP 或 IP:端口），如 1.1.1.1
+	DoHURL    string                       `yaml:"doh_url"`    // doh 使用的地址，如 https://cloudflare-dns.com/dns-query
+	CacheTTL  time.Duration                `yaml:"cache_ttl"`  // 解析结果缓存时长上限，按记录的最小 TTL 缓存且不超过该值；0 使用默认值（1m），负值表示不缓存
+	Hosts     map[string]string            `yaml:"hosts"`      // 全局 hosts 覆盖：域名 -> IP
+	UserHosts map[string]map[string]string `yaml:"user_hosts"` // 按注册 key 的 hosts 覆盖
+}
+
+// Resolver 将域名解析为 IP 地址。
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// dnsState 为生效的解析配置，由 SetDNS 整体替换。
+type dnsState struct {
+	mode      string
+	resolver  Resolver // local、doh 使用的解析器
+	servers   []string // tailnet 使用的 DNS 服务器
+	ttl       time.Duration
+	hosts     map[string]net.IP
+	userHosts map[string]map[string]net.IP
+	cache     *dnsCache
+	tailnet   *tailnetResolvers // tailnet 方式下按路由复用的解析器
+}
+
+var (
+	dnsLock    sync.RWMutex
+	currentDNS = &dnsState{mode: DNSRemote, cache: newDNSCache()}
+)
+
+// SetDNS 校验并设置目标域名的解析配置，同时清空解析缓存。
+func SetDNS(cfg DNSConfig) error {
+	st := &dnsState{mode: cfg.Mode, ttl: cfg.CacheTTL, cache: newDNSCache(), tailnet: &tailnetResolvers{}}
+	if st.mode == "" {
+		st.mode = DNSRemote
+	}
+	if st.ttl == 0 {
+		st.ttl = DefaultDNSCacheTTL
+	}
+	servers := make([]string, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		if net.ParseIP(strings.Trim(s, "[]")) != nil {
+			s = net.JoinHostPort(strings.Trim(s, "[]"), "53")
+		}
+		host, port, err := net.SplitHostPort(s)
+		if err != nil || net.ParseIP(host) == nil || port == "" {
+			return fmt.Errorf("DNS 服务器地址无效，须为 IP 或 IP:端口: %q", s)
+		}
+		servers = append(servers, s)
+	}
+	switch st.mode {
+	case DNSRemote:
+	case DNSLocal:
+		if len(servers) == 0 {
+			return errors.New("local 解析需要配置 servers")
+		}
+		st.resolver = newServerResolver(servers, nil)
+	case DNSDoH:
+		u, err := url.Parse(cfg.DoHURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("doh_url 无效: %q", cfg.DoHURL)
+		}
+		st.resolver = &DoHResolver{URL: cfg.DoHURL}
+	case DNSTailnet:
+		if len(servers) == 0 {
+			return errors.New("tailnet 解析需要配置 servers")
+		}
+		st.servers = servers
+	default:
+		return fmt.Errorf("不支持的解析方式: %q", cfg.Mode)
+	}
+	var err error
+	if st.hosts, err = parseHosts(cfg.Hosts); err != nil {
+		return err
+	}
+	st.userHosts = make(map[string]map[string]net.IP, len(cfg.UserHosts))
+	for key, hosts := range cfg.UserHosts {
+		if st.userHosts[key], err = parseHosts(hosts); err != nil {
+			return fmt.Errorf("注册 key %s: %w", key, err)
+		}
+	}
+	dnsLock.Lock()
+	defer dnsLock.Unlock()
+	currentDNS = st
+	return nil
+}
+
+// parseHosts 校验 hosts 覆盖，域名统一为小写。
+func parseHosts(hosts map[string]string) (map[string]net.IP, error) {
+	m := make(map[string]net.IP, len(hosts))
+	for host, s := range hosts {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("hosts 中 %s 的 IP 无效: %q", host, s)
+		}
+		m[normalizeHost(host)] = ip
+	}
+	return m, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func loadDNS() *dnsState {
+	dnsLock.RLock()
+	defer dnsLock.RUnlock()
+	return currentDNS
+}
+
+// hasUserHosts 判断注册 key 是否配置了 hosts 覆盖，此时经该 key 建立的连接不能与其他用户复用。
+func hasUserHosts(key string) bool {
+	return key != "" && len(loadDNS().userHosts[key]) > 0
+}
+
+// lookupHost 按当前解析配置解析 host，remote 方式使用系统解析器。
+// ctx 中的注册 key 用于选择用户的 hosts 覆盖与 tailnet 解析所经的路由。
+func lookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	st := loadDNS()
+	key := userParamsFromContext(ctx).Key
+	host = normalizeHost(host)
+	if ip, ok := st.override(key, host); ok {
+		return []net.IP{ip}, nil
+	}
+	r, scope := st.resolverFor(key)
+	if r == nil {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+	return st.lookup(ctx, r, scope, host)
+}
+
+// resolveAddr 按当前解析配置将 addr 中的域名替换为 IP，有多个地址时按用户的地址族偏好选择。
+// remote 方式且没有 hosts 覆盖时原样返回，由下游节点或系统解析器解析。
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	st := loadDNS()
+	key := userParamsFromContext(ctx).Key
+	host = normalizeHost(host)
+	if ip, ok := st.override(key, host); ok {
+		return net.JoinHostPort(ip.String(), port), nil
+	}
+	r, scope := st.resolverFor(key)
+	if r == nil {
+		return addr, nil
+	}
+	ips, err := st.lookup(ctx, r, scope, host)
+	if err != nil {
+		return "", &DialError{Addr: addr, Kind: ErrHostUnreachable, Err: err}
+	}
+	ips = orderIPs(ips, "tcp", loadDualStack().family(key))
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// override 返回 host 的 hosts 覆盖，用户的 hosts 优先。
+func (st *dnsState) override(key, host string) (net.IP, bool) {
+	if ip, ok := st.userHosts[key][host]; ok && key != "" {
+		return ip, true
+	}
+	ip, ok := st.hosts[host]
+	return ip, ok
+}
+
+// resolverFor 返回用户使用的解析器与缓存作用域，remote 方式或 tailnet 方式下找不到用户路由时返回 nil。
+func (st *dnsState) resolverFor(key string) (Resolver, string) {
+	if st.mode != DNSTailnet {
+		return st.resolver, ""
+	}
+	userProxyMapLock.RLock()
+	route := UserProxyMap[key+":"+key]
+	userProxyMapLock.RUnlock()
+	if key == "" || route == "" {
+		return nil, ""
+	}
+	d, err := DialerFor(route)
+	if err != nil {
+		return nil, ""
+	}
+	return st.tailnet.get(route, d, st.servers), route
+}
+
+// tailnetResolvers 按路由缓存 tailnet 方式的解析器，同一路由的解析请求共用服务器轮换状态。
+// 路由的 Dialer 更换后重新创建；Dialer 缓存变更时移除已失效路由的解析器。
+type tailnetResolvers struct {
+	mu        sync.Mutex
+	resolvers map[string]tailnetResolver
+	gen       uint64 // 上次清理时的 Dialer 缓存代数
+}
+
+type tailnetResolver struct {
+	dialer   Dialer
+	resolver *serverResolver
+}
+
+// get 返回经 d（路由 route 的 Dialer）访问 servers 的解析器。
+func (t *tailnetResolvers) get(route string, d Dialer, servers []string) *serverResolver {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gen := dialerCacheGen.Load(); gen != t.gen {
+		for r, e := range t.resolvers {
+			if cachedDialer(r) != e.dialer {
+				delete(t.resolvers, r)
+			}
+		}
+		t.gen = gen
+	}
+	if e, ok := t.resolvers[route]; ok && e.dialer == d {
+		return e.resolver
+	}
+	if t.resolvers == nil {
+		t.resolvers = make(map[string]tailnetResolver)
+	}
+	r := newServerResolver(servers, d)
+	t.resolvers[route] = tailnetResolver{dialer: d, resolver: r}
+	return r
+}
+
+// lookup 经缓存解析 host，scope 区分经不同路由解析的结果。
+// 解析器给出记录 TTL 时按记录的最小 TTL 缓存，cache_ttl 为缓存时长上限。
+func (st *dnsState) lookup(ctx context.Context, r Resolver, scope, host string) ([]net.IP, error) {
+	cacheKey := scope + "|" + host
+	if ips, ok := st.cache.get(cacheKey); ok {
+		return ips, nil
+	}
+	var ips []net.IP
+	var err error
+	ttl := st.ttl
+	if tr, ok := r.(ttlResolver); ok {
+		var recordTTL time.Duration
+		ips, recordTTL, err = tr.lookupIPTTL(ctx, host)
+		if recordTTL < ttl {
+			ttl = recordTTL
+		}
+	} else {
+		ips, err = r.LookupIP(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if ttl > 0 {
+		st.cache.put(cacheKey, ips, ttl)
+	}
+	log.Printf("DNS: resolved %s via %s: %v", host, st.mode, ips)
+	return ips, nil
+}
+
+// ========== 解析器 ===========
+// dnsQueryTimeout 为向单个 DNS 服务器发送一次查询的超时。
+const dnsQueryTimeout = 5 * time.Second
+
+// ttlResolver 由能给出记录 TTL 的解析器实现（local、tailnet 与 doh）；
+// 其余 Resolver（如基于 net.Resolver 的实现）的结果按固定的 cache_ttl 缓存。
+type ttlResolver interface {
+	// lookupIPTTL 解析 host，返回地址与应答中记录的最小 TTL。
+	lookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+}
+
+// dnsExchangeFunc 发送一次 DNS 查询报文并返回应答报文。
+type dnsExchangeFunc func(ctx context.Context, query []byte) ([]byte, error)
+
+// lookupIPVia 经 exchange 依次查询 host 的 A 与 AAAA 记录，IPv4 地址在前。
+// 返回地址及含地址的应答中记录（包括 CNAME）的最小 TTL。
+func lookupIPVia(ctx context.Context, host string, exchange dnsExchangeFunc) ([]net.IP, time.Duration, error) {
+	var ips []net.IP
+	var ttl time.Duration
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		got, recordTTL, err := queryDNS(ctx, host, qtype, exchange)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(got) == 0 {
+			continue
+		}
+		if len(ips) == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		ips = append(ips, got...)
+	}
+	if len(ips) == 0 && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	return ips, ttl, nil
+}
+
+// queryDNS 发送一次 qtype 查询，返回应答中 qtype 类型的地址与应答记录的最小 TTL。
+func queryDNS(ctx context.Context, host string, qtype dnsmessage.Type, exchange dnsExchangeFunc) ([]net.IP, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := exchange(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, 0, fmt.Errorf("DNS 应答无效: %w", err)
+	}
+	if msg.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, &net.DNSError{Err: msg.RCode.String(), Name: host, IsNotFound: msg.RCode == dnsmessage.RCodeNameError}
+	}
+	var ips []net.IP
+	var ttl uint32
+	for i, ans := range msg.Answers {
+		if i == 0 || ans.Header.TTL < ttl {
+			ttl = ans.Header.TTL
+		}
+		switch b := ans.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(b.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(b.AAAA[:]))
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// serverResolver 经指定的 DNS 服务器解析，dialer 非 nil 时经该 Dialer（用户的路由）以 TCP 访问服务器，
+// 否则使用 UDP，应答被截断时改用 TCP。
+type serverResolver struct {
+	servers []string
+	next    uint32
+	dialer  Dialer
+}
+
+func newServerResolver(servers []string, dialer Dialer) *serverResolver {
+	return &serverResolver{servers: servers, dialer: dialer}
+}
+
+// LookupIP 解析 host 的 IPv4 与 IPv6 地址。
+func (s *serverResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := s.lookupIPTTL(ctx, host)
+	return ips, err
+}
+
+func (s *serverResolver) lookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	return lookupIPVia(ctx, host, s.exchange)
+}
+
+// exchange 从轮换位置开始依次向各服务器发送查询，服务器出错、超时或返回 SERVFAIL、REFUSED 时尝试下一个。
+func (s *serverResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	start := int(atomic.AddUint32(&s.next, 1) - 1)
+	var lastErr error
+	for i := range s.servers {
+		server := s.servers[(start+i)%len(s.servers)]
+		resp, err := s.exchangeWith(ctx, server, query)
+		if err == nil {
+			var p dnsmessage.Parser
+			h, perr := p.Start(resp)
+			switch {
+			case perr != nil:
+				err = fmt.Errorf("DNS 应答无效: %w", perr)
+			case h.RCode == dnsmessage.RCodeServerFailure || h.RCode == dnsmessage.RCodeRefused:
+				err = &net.DNSError{Err: h.RCode.String(), Server: server}
+			default:
+				return resp, nil
+			}
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// exchangeWith 向 server 发送一次查询。
+func (s *serverResolver) exchangeWith(ctx context.Context, server string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+	if s.dialer == nil {
+		resp, err := exchangeUDP(ctx, server, query)
+		if err != nil {
+			return nil, err
+		}
+		var p dnsmessage.Parser
+		if h, err := p.Start(resp); err != nil || !h.Truncated {
+			return resp, nil
+		}
+	}
+	var conn net.Conn
+	var err error
+	if s.dialer != nil {
+		// 解析请求不参与节点池的会话固定与标签筛选
+		conn, err = s.dialer.DialContext(context.WithValue(ctx, userParamsKey{}, UserParams{}), "tcp", server)
+	} else {
+		conn, err = dialNet(ctx, "tcp", server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return exchangeStream(ctx, conn, query)
+}
+
+// exchangeUDP 经 UDP 向 server 发送查询，使用随机 ID 并忽略 ID 不匹配的应答。
+func exchangeUDP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	conn, err := dialNet(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query = bytes.Clone(query)
+	id := uint16(rand.Uint32())
+	binary.BigEndian.PutUint16(query, id)
+	var resp []byte
+	err = handshakeContext(ctx, conn, func() error {
+		if _, err := conn.Write(query); err != nil {
+			return err
+		}
+		buf := make([]byte, 1500)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return err
+			}
+			if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+				resp = buf[:n]
+				return nil
+			}
+		}
+	})
+	return resp, err
+}
+
+// exchangeStream 在流式连接上按 RFC 1035 4.2.2（2 字节长度前缀）发送查询并读取应答。
+func exchangeStream(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	var resp []byte
+	err := handshakeContext(ctx, conn, func() error {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			return err
+		}
+		var size uint16
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		resp = make([]byte, size)
+		_, err := io.ReadFull(conn, resp)
+		return err
+	})
+	return resp, err
+}
+
+// DoHResolver 经 DNS-over-HTTPS（RFC 8484，POST application/dns-message）解析。
+type DoHResolver struct {
+	URL    string
+	Client *http.Client // 为 nil 时使用 http.DefaultClient
+}
+
+// LookupIP 依次查询 A 与 AAAA 记录，IPv4 地址在前。
+func (r *DoHResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, _, err := r.lookupIPTTL(ctx, host)
+	return ips, err
+}
+
+func (r *DoHResolver) lookupIPTTL(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	return lookupIPVia(ctx, host, r.exchange)
+}
+
+// exchange 发送一次 DoH 查询，返回应答报文。
+func (r *DoHResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH 服务器返回 %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+// ========== 解析缓存 ===========
+// dnsCache 缓存解析结果，过期条目每分钟清理一次。
+type dnsCache struct {
+	mu        sync.Mutex
+	entries   map[string]dnsEntry
+	lastSweep time.Time
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{entries: make(map[string]dnsEntry), lastSweep: time.Now()}
+}
+
+func (c *dnsCache) get(key string) ([]net.IP, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.ips, true
+}
+
+func (c *dnsCache) put(key string, ips []net.IP, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = dnsEntry{ips: ips, expires: now.Add(ttl)}
+}
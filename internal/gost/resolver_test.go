@@ -0,0 +1,354 @@
+package gost
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answerDNS 按 records（域名 -> IPv4）应答 A 查询，其余查询返回空应答，未收录的域名返回 NXDOMAIN
+func answerDNS(query []byte, records map[string]string) []byte {
+	return answerDNSWithTTL(query, records, 60)
+}
+
+// answerDNSWithTTL 与 answerDNS 相同，A 记录的 TTL 为 ttl 秒
+func answerDNSWithTTL(query []byte, records map[string]string, ttl uint32) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) == 0 {
+		return nil
+	}
+	q := msg.Questions[0]
+	msg.Header.Response = true
+	ip, ok := records[q.Name.String()]
+	if !ok {
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	} else if q.Type == dnsmessage.TypeA {
+		var a [4]byte
+		copy(a[:], net.ParseIP(ip).To4())
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: a},
+		}}
+	}
+	resp, _ := msg.Pack()
+	return resp
+}
+
+// startTestDNSServer 在同一端口启动 UDP 与 TCP DNS 服务器，返回地址与收到的 A 查询次数
+func startTestDNSServer(t *testing.T, records map[string]string) (string, *int32) {
+	t.Helper()
+	var queries int32
+	count := func(query []byte) {
+		var p dnsmessage.Parser
+		if _, err := p.Start(query); err == nil {
+			if q, err := p.Question(); err == nil && q.Type == dnsmessage.TypeA {
+				atomic.AddInt32(&queries, 1)
+			}
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	pc, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to create UDP listener: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			count(buf[:n])
+			pc.WriteTo(answerDNS(buf[:n], records), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					var size uint16
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					query := make([]byte, size)
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					count(query)
+					resp := answerDNS(query, records)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), &queries
+}
+
+// startRecordingSOCKS5 启动一个无认证的下游 SOCKS5 代理，记录 CONNECT 请求中的目标地址后应答成功
+func startRecordingSOCKS5(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	targets := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00})
+				hdr := make([]byte, 4)
+				if _, err := io.ReadFull(conn, hdr); err != nil {
+					return
+				}
+				target, err := readSOCKS5Addr(conn, hdr[3])
+				if err != nil {
+					return
+				}
+				targets <- target
+				writeSOCKS5Reply(conn, RepSucceeded, "127.0.0.1:0")
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), targets
+}
+
+// TestSetDNS 测试解析配置校验
+func TestSetDNS(t *testing.T) {
+	defer SetDNS(DNSConfig{})
+	tests := []struct {
+		name    string
+		cfg     DNSConfig
+		wantErr bool
+	}{
+		{"默认 remote", DNSConfig{}, false},
+		{"local", DNSConfig{Mode: DNSLocal, Servers: []string{"1.1.1.1", "[2606:4700:4700::1111]:53"}}, false},
+		{"doh", DNSConfig{Mode: DNSDoH, DoHURL: "https://cloudflare-dns.com/dns-query"}, false},
+		{"tailnet", DNSConfig{Mode: DNSTailnet, Servers: []string{"100.100.100.100"}}, false},
+		{"未知方式", DNSConfig{Mode: "system"}, true},
+		{"local 缺少服务器", DNSConfig{Mode: DNSLocal}, true},
+		{"服务器不是 IP", DNSConfig{Mode: DNSLocal, Servers: []string{"dns.google"}}, true},
+		{"doh 不是 https", DNSConfig{Mode: DNSDoH, DoHURL: "http://1.1.1.1/dns-query"}, true},
+		{"hosts IP 无效", DNSConfig{Hosts: map[string]string{"example.com": "not-an-ip"}}, true},
+		{"用户 hosts IP 无效", DNSConfig{UserHosts: map[string]map[string]string{"k": {"example.com": "1.2.3"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetDNS(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("期望错误=%v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestResolveAddr 测试各解析方式、hosts 覆盖与缓存
+func TestResolveAddr(t *testing.T) {
+	defer SetDNS(DNSConfig{})
+	dnsAddr, queries := startTestDNSServer(t, map[string]string{"app.test.": "10.9.8.7"})
+	userCtx := withUserParams(context.Background(), UserParams{Key: "dnskey"})
+
+	t.Run("remote 原样返回域名", func(t *testing.T) {
+		if err := SetDNS(DNSConfig{Hosts: map[string]string{"Pinned.test": "10.0.0.1"}}); err != nil {
+			t.Fatalf("设置解析配置失败: %v", err)
+		}
+		if got, _ := resolveAddr(context.Background(), "app.test:443"); got != "app.test:443" {
+			t.Errorf("remote 方式应原样返回，实际 %s", got)
+		}
+		if got, _ := resolveAddr(context.Background(), "pinned.test.:443"); got != "10.0.0.1:443" {
+			t.Errorf("hosts 覆盖在 remote 方式下应生效，实际 %s", got)
+		}
+	})
+
+	t.Run("local 解析与缓存", func(t *testing.T) {
+		err := SetDNS(DNSConfig{
+			Mode:      DNSLocal,
+			Servers:   []string{dnsAddr},
+			Hosts:     map[string]string{"pinned.test": "10.0.0.1"},
+			UserHosts: map[string]map[string]string{"dnskey": {"pinned.test": "10.0.0.2"}},
+		})
+		if err != nil {
+			t.Fatalf("设置解析配置失败: %v", err)
+		}
+		before := atomic.LoadInt32(queries)
+		for i := 0; i < 3; i++ {
+			if got, err := resolveAddr(context.Background(), "app.test:443"); err != nil || got != "10.9.8.7:443" {
+				t.Fatalf("期望 10.9.8.7:443，实际 %s, err=%v", got, err)
+			}
+		}
+		if n := atomic.LoadInt32(queries) - before; n != 1 {
+			t.Errorf("缓存有效期内应只查询一次，实际 %d 次", n)
+		}
+		if got, _ := resolveAddr(userCtx, "pinned.test:80"); got != "10.0.0.2:80" {
+			t.Errorf("用户 hosts 应优先，实际 %s", got)
+		}
+		if got, _ := resolveAddr(context.Background(), "pinned.test:80"); got != "10.0.0.1:80" {
+			t.Errorf("其他用户应使用全局 hosts，实际 %s", got)
+		}
+		if !hasUserHosts("dnskey") || hasUserHosts("otherkey") {
+			t.Errorf("hasUserHosts 结果不符合预期")
+		}
+		_, err = resolveAddr(context.Background(), "missing.test:80")
+		if !errors.Is(err, ErrHostUnreachable) {
+			t.Errorf("未收录的域名期望 ErrHostUnreachable，实际 %v", err)
+		}
+	})
+
+	t.Run("tailnet 经用户路由解析", func(t *testing.T) {
+		if err := SetDNS(DNSConfig{Mode: DNSTailnet, Servers: []string{dnsAddr}}); err != nil {
+			t.Fatalf("设置解析配置失败: %v", err)
+		}
+		// 用户路由为一层 SOCKS5 节点，解析请求经该节点以 TCP 发往 DNS 服务器
+		socksAddr := startFakeSOCKS5WithBND(t, "127.0.0.1:0")
+		userProxyMapLock.Lock()
+		UserProxyMap["dnskey:dnskey"] = "socks5://" + socksAddr
+		userProxyMapLock.Unlock()
+		if got, err := resolveAddr(userCtx, "app.test:443"); err != nil || got != "10.9.8.7:443" {
+			t.Errorf("期望 10.9.8.7:443，实际 %s, err=%v", got, err)
+		}
+		if got, _ := resolveAddr(context.Background(), "app.test:443"); got != "app.test:443" {
+			t.Errorf("没有用户路由时应原样返回，实际 %s", got)
+		}
+		// 同一路由复用解析器，保留服务器轮换状态；路由变更后重新创建
+		st := loadDNS()
+		r1, _ := st.resolverFor("dnskey")
+		r2, _ := st.resolverFor("dnskey")
+		if r1 == nil || r1 != r2 {
+			t.Errorf("同一路由应复用解析器")
+		}
+		otherAddr := startFakeSOCKS5WithBND(t, "127.0.0.1:0")
+		userProxyMapLock.Lock()
+		UserProxyMap["dnskey:dnskey"] = "socks5://" + otherAddr
+		userProxyMapLock.Unlock()
+		if r3, _ := st.resolverFor("dnskey"); r3 == nil || r3 == r1 {
+			t.Errorf("路由变更后应使用新的解析器")
+		}
+		userProxyMapLock.Lock()
+		delete(UserProxyMap, "dnskey:dnskey")
+		userProxyMapLock.Unlock()
+	})
+
+	t.Run("SOCKS5 下游收到解析后的地址", func(t *testing.T) {
+		if err := SetDNS(DNSConfig{Mode: DNSLocal, Servers: []string{dnsAddr}}); err != nil {
+			t.Fatalf("设置解析配置失败: %v", err)
+		}
+		downstream, targets := startRecordingSOCKS5(t)
+		d := &SOCKS5Dialer{Addr: downstream}
+		conn, err := d.DialContext(context.Background(), "tcp", "app.test:8443")
+		if err != nil {
+			t.Fatalf("经 SOCKS5 下游建连失败: %v", err)
+		}
+		conn.Close()
+		select {
+		case got := <-targets:
+			if got != "10.9.8.7:8443" {
+				t.Errorf("下游应收到解析后的地址，实际 %s", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("下游未收到 CONNECT 请求")
+		}
+	})
+}
+
+// TestDoHResolver 测试 DNS-over-HTTPS 查询
+func TestDoHResolver(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerDNS(query, map[string]string{"doh.test.": "10.1.1.1"}))
+	}))
+	defer server.Close()
+	r := &DoHResolver{URL: server.URL + "/dns-query", Client: server.Client()}
+
+	ips, err := r.LookupIP(context.Background(), "doh.test")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.1.1.1")) {
+		t.Errorf("期望 [10.1.1.1]，实际 %v, err=%v", ips, err)
+	}
+	_, err = r.LookupIP(context.Background(), "missing.test")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("未收录的域名期望 NXDOMAIN，实际 %v", err)
+	}
+	t.Logf("✅ DoH 查询成功: %v", ips)
+}
+
+// TestDNSCacheRecordTTL 测试解析结果按记录的最小 TTL 缓存，cache_ttl 为上限，TTL 为 0 的记录不缓存
+func TestDNSCacheRecordTTL(t *testing.T) {
+	var recordTTL atomic.Uint32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerDNSWithTTL(query, map[string]string{"ttl.test.": "10.1.1.1"}, recordTTL.Load()))
+	}))
+	defer server.Close()
+	doh := &DoHResolver{URL: server.URL + "/dns-query", Client: server.Client()}
+	dnsAddr, _ := startTestDNSServer(t, map[string]string{"ttl.test.": "10.1.1.1"})
+	local := newServerResolver([]string{dnsAddr}, nil)
+
+	tests := []struct {
+		name      string
+		resolver  Resolver
+		recordTTL uint32
+		cacheTTL  time.Duration
+		want      time.Duration // 0 表示不缓存
+	}{
+		{"doh 记录 TTL 短于上限", doh, 30, time.Minute, 30 * time.Second},
+		{"doh 上限短于记录 TTL", doh, 3600, 10 * time.Second, 10 * time.Second},
+		{"doh 记录 TTL 为 0", doh, 0, time.Minute, 0},
+		{"local 记录 TTL", local, 0, 5 * time.Minute, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recordTTL.Store(tt.recordTTL)
+			st := &dnsState{mode: DNSLocal, ttl: tt.cacheTTL, cache: newDNSCache()}
+			start := time.Now()
+			if _, err := st.lookup(context.Background(), tt.resolver, "", "ttl.test"); err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			e, ok := st.cache.entries["|ttl.test"]
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("TTL 为 0 的记录不应缓存")
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("解析结果未缓存")
+			}
+			if got := e.expires.Sub(start); got < tt.want || got > tt.want+time.Second {
+				t.Errorf("期望缓存 %s，实际 %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package gost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 规则动作
+const (
+	ActionDirect = "direct" // 直连目标地址
+	ActionRoute  = "route"  // 经命名路由转发
+	ActionReject = "reject" // 拒绝连接
+)
+
+// Rule 为一条按目标地址选择路由的规则。
+// 同一条件内的多个取值任一命中即可，设置了多个条件时须全部命中；未设置任何条件的规则匹配所有目标。
+type Rule struct {
+	Domain      []string `json:"domain,omitempty" yaml:"domain,omitempty"`             // 域名后缀，example.com 同时匹配其子域名
+	DomainRegex []string `json:"domain_regex,omitempty" yaml:"domain_regex,omitempty"` // 域名正则表达式
+	CIDR        []string `json:"cidr,omitempty" yaml:"cidr,omitempty"`                 // 目标 IP 网段，目标为域名时按解析结果匹配
+	Port        []string `json:"port,omitempty" yaml:"port,omitempty"`                 // 端口或端口范围，如 443、8000-9000
+	GeoIP       []string `json:"geoip,omitempty" yaml:"geoip,omitempty"`               // 目标 IP 所属国家代码，如 cn、us，需配置 GeoIP 数据库
+	Action      string   `json:"action" yaml:"action"`                                 // direct、route 或 reject
+	Route       string   `json:"route,omitempty" yaml:"route,omitempty"`               // action 为 route 时的命名路由
+}
+
+// compiledRule 为校验并预处理后的规则。
+type compiledRule struct {
+	rule    Rule
+	domains []string
+	regexes []*regexp.Regexp
+	nets    []*net.IPNet
+	ports   [][2]int
+	geoip   map[string]bool
+	route   string // 命中后使用的路由：直连为 "direct"，命名路由为其 JSON 形式
+}
+
+// RuleSet 为有序的规则列表，按顺序匹配，第一条命中的规则生效。
+type RuleSet struct {
+	rules []*compiledRule
+}
+
+// NewRuleSet 校验并编译规则，named 为 action 为 route 时可引用的命名路由（规范 JSON）。
+func NewRuleSet(rules []Rule, named map[string]string) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for i, r := range rules {
+		c, err := compileRule(r, named)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 条规则: %w", i+1, err)
+		}
+		rs.rules = append(rs.rules, c)
+	}
+	return rs, nil
+}
+
+func compileRule(r Rule, named map[string]string) (*compiledRule, error) {
+	c := &compiledRule{rule: r}
+	switch r.Action {
+	case ActionDirect:
+		c.route = "direct"
+	case ActionReject:
+	case ActionRoute:
+		route, ok := named[r.Route]
+		if !ok {
+			return nil, fmt.Errorf("未定义的命名路由: %q", r.Route)
+		}
+		c.route = route
+	default:
+		return nil, fmt.Errorf("不支持的规则动作: %q", r.Action)
+	}
+	if r.Route != "" && r.Action != ActionRoute {
+		return nil, fmt.Errorf("route 仅用于 action 为 route 的规则")
+	}
+	for _, d := range r.Domain {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		if d == "" {
+			return nil, fmt.Errorf("域名后缀不能为空")
+		}
+		c.domains = append(c.domains, d)
+	}
+	for _, expr := range r.DomainRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("域名正则无效: %w", err)
+		}
+		c.regexes = append(c.regexes, re)
+	}
+	nets, err := ParseCIDRs(r.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	c.nets = nets
+	for _, p := range r.Port {
+		lo, hi, ok := strings.Cut(p, "-")
+		if !ok {
+			hi = lo
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		to, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || from < 1 || to > 0xffff || from > to {
+			return nil, fmt.Errorf("端口范围无效: %q", p)
+		}
+		c.ports = append(c.ports, [2]int{from, to})
+	}
+	if len(r.GeoIP) > 0 {
+		if currentGeoIP() == nil {
+			return nil, fmt.Errorf("geoip 规则需要配置 GeoIP 数据库")
+		}
+		c.geoip = make(map[string]bool, len(r.GeoIP))
+		for _, cc := range r.GeoIP {
+			c.geoip[strings.ToUpper(strings.TrimSpace(cc))] = true
+		}
+	}
+	return c, nil
+}
+
+// DecodeRules 解析 JSON 数组形式的规则列表，未知字段视为错误。
+func DecodeRules(s string) ([]Rule, error) {
+	var rules []Rule
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("规则格式无效: %w", err)
+	}
+	return rules, nil
+}
+
+// ruleTarget 为规则匹配的目标，目标为域名时按需解析一次 IP。
+type ruleTarget struct {
+	ctx      context.Context
+	host     string // 小写，不含末尾的 "."
+	port     int
+	ips      []net.IP
+	resolved bool
+}
+
+func newRuleTarget(ctx context.Context, addr string) *ruleTarget {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	port, _ := strconv.Atoi(portStr)
+	t := &ruleTarget{ctx: ctx, host: strings.ToLower(strings.TrimSuffix(host, ".")), port: port}
+	if ip := net.ParseIP(t.host); ip != nil {
+		t.ips, t.resolved = []net.IP{ip}, true
+	}
+	return t
+}
+
+// isDomain 判断目标是否为域名（而非 IP 地址）。
+func (t *ruleTarget) isDomain() bool {
+	return net.ParseIP(t.host) == nil
+}
+
+// addrs 返回目标 IP，域名在首次调用时解析，解析失败时为空。
+func (t *ruleTarget) addrs() []net.IP {
+	if !t.resolved {
+		t.resolved = true
+		ips, err := ruleLookupIP(t.ctx, t.host)
+		if err != nil {
+			log.Printf("Rule: resolve %s failed: %v", t.host, err)
+		}
+		t.ips = ips
+	}
+	return t.ips
+}
+
+// ruleLookupIP 解析规则匹配所需的目标 IP，与建连使用相同的解析配置。
+var ruleLookupIP = lookupHost
+
+// match 判断规则是否命中目标，各条件按开销从低到高依次判断。
+func (c *compiledRule) match(t *ruleTarget) bool {
+	if len(c.ports) > 0 && !c.matchPort(t.port) {
+		return false
+	}
+	if len(c.domains) > 0 || len(c.regexes) > 0 {
+		if !t.isDomain() || !c.matchDomain(t.host) {
+			return false
+		}
+	}
+	if len(c.nets) > 0 && !c.matchIP(t.addrs(), func(ip net.IP) bool {
+		for _, n := range c.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return false
+	}
+	if len(c.geoip) > 0 {
+		g := currentGeoIP()
+		if g == nil || !c.matchIP(t.addrs(), func(ip net.IP) bool {
+			cc, err := g.Country(ip)
+			return err == nil && c.geoip[cc]
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *compiledRule) matchPort(port int) bool {
+	for _, r := range c.ports {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain 判断域名是否命中后缀或正则，两类条件同时设置时任一命中即可。
+func (c *compiledRule) matchDomain(host string) bool {
+	for _, d := range c.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	for _, re := range c.regexes {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledRule) matchIP(ips []net.IP, fn func(net.IP) bool) bool {
+	for _, ip := range ips {
+		if fn(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// match 返回第一条命中目标的规则，均未命中时返回 nil。
+func (rs *RuleSet) match(t *ruleTarget) (int, *compiledRule) {
+	if rs == nil {
+		return 0, nil
+	}
+	for i, c := range rs.rules {
+		if c.match(t) {
+			return i, c
+		}
+	}
+	return 0, nil
+}
+
+// ========== 规则配置 ===========
+var (
+	rulesLock   sync.RWMutex
+	globalRules *RuleSet
+	userRules   = make(map[string]*RuleSet) // 按注册 key 的规则
+	namedRoutes = make(map[string]string)   // 命名路由（规范 JSON）
+	geoIPDB     *GeoIP
+)
+
+// SetGeoIP 设置规则匹配 geoip 条件使用的数据库，须在 SetRules 之前调用。
+func SetGeoIP(g *GeoIP) {
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	geoIPDB = g
+}
+
+func currentGeoIP() *GeoIP {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	return geoIPDB
+}
+
+// SetRules 校验并设置命名路由与全局规则，全局规则在各用户的规则之后匹配。
+// 用户规则引用命名路由，修改命名路由后须重新加载用户规则。
+func SetRules(global []Rule, named map[string]Route) error {
+	canonical := make(map[string]string, len(named))
+	for name, r := range named {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("命名路由 %s: %w", name, err)
+		}
+		canonical[name] = r.String()
+	}
+	rs, err := NewRuleSet(global, canonical)
+	if err != nil {
+		return err
+	}
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	namedRoutes = canonical
+	globalRules = rs
+	return nil
+}
+
+// SetUserRules 校验并设置注册 key 的规则，rules 为空时删除该 key 的规则。
+func SetUserRules(key string, rules []Rule) error {
+	rulesLock.RLock()
+	named := namedRoutes
+	rulesLock.RUnlock()
+	rs, err := NewRuleSet(rules, named)
+	if err != nil {
+		return &RouteError{Key: key, Err: err}
+	}
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	if len(rules) == 0 {
+		delete(userRules, key)
+	} else {
+		userRules[key] = rs
+	}
+	return nil
+}
+
+// ErrRuleRejected 为目标地址被规则拒绝时的底层错误。
+var ErrRuleRejected = errors.New("rejected by rule")
+
+// applyRules 按注册 key 的规则、全局规则的顺序为目标地址选择路由，均未命中时使用用户的路由 route。
+// key 为空（匿名访问）时只匹配全局规则。命中 reject 规则时返回 ErrNotAllowed 分类的 DialError。
+func applyRules(ctx context.Context, key, route, addr string) (string, error) {
+	rulesLock.RLock()
+	user, global := userRules[key], globalRules
+	rulesLock.RUnlock()
+	if user == nil && global == nil {
+		return route, nil
+	}
+	t := newRuleTarget(withUserParams(ctx, UserParams{Key: key}), addr)
+	scope, i, c := "user", 0, (*compiledRule)(nil)
+	if key != "" {
+		i, c = user.match(t)
+	}
+	if c == nil {
+		scope = "global"
+		i, c = global.match(t)
+	}
+	if c == nil {
+		return route, nil
+	}
+	log.Printf("Rule: %s matched %s rule %d, action %s", addr, scope, i+1, c.rule.Action)
+	if c.rule.Action == ActionReject {
+		return "", &DialError{Addr: addr, Kind: ErrNotAllowed, Err: ErrRuleRejected}
+	}
+	return c.route, nil
+}
@@ -0,0 +1,286 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// stubRuleLookup 将规则匹配的域名解析替换为固定映射，测试结束后恢复
+func stubRuleLookup(t *testing.T, hosts map[string]string) {
+	t.Helper()
+	old := ruleLookupIP
+	ruleLookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		if ip, ok := hosts[host]; ok {
+			return []net.IP{net.ParseIP(ip)}, nil
+		}
+		return nil, fmt.Errorf("no such host: %s", host)
+	}
+	t.Cleanup(func() { ruleLookupIP = old })
+}
+
+// TestRuleMatch 测试单条规则对目标地址的匹配
+func TestRuleMatch(t *testing.T) {
+	SetGeoIP(newTestGeoIP(t))
+	defer SetGeoIP(nil)
+	stubRuleLookup(t, map[string]string{"us.test": "1.2.3.4", "de.test": "5.6.7.8"})
+
+	tests := []struct {
+		name string
+		rule Rule
+		addr string
+		want bool
+	}{
+		{"域名后缀匹配子域名", Rule{Domain: []string{"example.com"}}, "api.example.com:443", true},
+		{"域名后缀匹配自身", Rule{Domain: []string{".Example.com"}}, "example.com:443", true},
+		{"域名后缀不匹配相似域名", Rule{Domain: []string{"example.com"}}, "badexample.com:443", false},
+		{"域名条件不匹配 IP 目标", Rule{Domain: []string{"example.com"}}, "93.184.216.34:443", false},
+		{"域名正则", Rule{DomainRegex: []string{`^ads\.`}}, "ads.tracker.net:80", true},
+		{"网段匹配 IP 目标", Rule{CIDR: []string{"10.0.0.0/8"}}, "10.1.2.3:22", true},
+		{"网段匹配域名解析结果", Rule{CIDR: []string{"1.2.3.0/24"}}, "us.test:80", true},
+		{"域名解析失败", Rule{CIDR: []string{"0.0.0.0/0"}}, "unknown.test:80", false},
+		{"端口范围", Rule{Port: []string{"8000-9000"}}, "example.com:8080", true},
+		{"端口不在范围内", Rule{Port: []string{"22", "8000-9000"}}, "example.com:80", false},
+		{"GeoIP 匹配", Rule{GeoIP: []string{"us"}}, "us.test:443", true},
+		{"GeoIP 不匹配", Rule{GeoIP: []string{"us"}}, "de.test:443", false},
+		{"GeoIP 匹配 IP 目标", Rule{GeoIP: []string{"cn", "de"}}, "5.6.7.8:80", true},
+		{"多个条件须同时命中", Rule{Domain: []string{"example.com"}, Port: []string{"443"}}, "example.com:80", false},
+		{"无条件匹配所有目标", Rule{}, "example.com:80", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Action = ActionDirect
+			c, err := compileRule(tt.rule, nil)
+			if err != nil {
+				t.Fatalf("规则无效: %v", err)
+			}
+			if got := c.match(newRuleTarget(context.Background(), tt.addr)); got != tt.want {
+				t.Errorf("%s 期望匹配=%v，实际 %v", tt.addr, tt.want, got)
+			}
+		})
+	}
+}
+
+// TestNewRuleSetInvalid 测试加载时拒绝无效规则
+func TestNewRuleSetInvalid(t *testing.T) {
+	named := map[string]string{"node-a": `{"direct":true}`}
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"未知动作", Rule{Action: "block"}},
+		{"未定义的命名路由", Rule{Action: ActionRoute, Route: "node-b"}},
+		{"非 route 动作指定路由", Rule{Action: ActionDirect, Route: "node-a"}},
+		{"正则无效", Rule{Action: ActionReject, DomainRegex: []string{"("}}},
+		{"网段无效", Rule{Action: ActionReject, CIDR: []string{"10.0.0.0/33"}}},
+		{"端口为 0", Rule{Action: ActionReject, Port: []string{"0"}}},
+		{"端口范围颠倒", Rule{Action: ActionReject, Port: []string{"9000-8000"}}},
+		{"未配置 GeoIP 数据库", Rule{Action: ActionReject, GeoIP: []string{"us"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRuleSet([]Rule{{Action: ActionDirect}, tt.rule}, named); err == nil {
+				t.Errorf("期望返回错误")
+			}
+		})
+	}
+	if _, err := DecodeRules(`[{"domain":["example.com"],"action":"reject","unknown":1}]`); err == nil {
+		t.Errorf("未知字段应返回错误")
+	}
+}
+
+// TestApplyRules 测试用户规则优先于全局规则，均未命中时使用用户路由
+func TestApplyRules(t *testing.T) {
+	stubRuleLookup(t, map[string]string{"intranet.test": "10.0.0.5"})
+	err := SetRules([]Rule{
+		{Domain: []string{"blocked.test"}, Action: ActionReject},
+		{Port: []string{"8000-9000"}, Action: ActionRoute, Route: "node-a"},
+	}, map[string]Route{"node-a": {Hops: []Hop{{Type: HopSOCKS5, Addr: "100.64.0.1:1080"}}}})
+	if err != nil {
+		t.Fatalf("设置全局规则失败: %v", err)
+	}
+	defer SetRules(nil, nil)
+	if err := SetUserRules("applykey", []Rule{
+		{CIDR: []string{"10.0.0.0/8"}, Action: ActionDirect},
+		{Domain: []string{"allowed.blocked.test"}, Action: ActionDirect},
+	}); err != nil {
+		t.Fatalf("设置用户规则失败: %v", err)
+	}
+	defer SetUserRules("applykey", nil)
+
+	tests := []struct {
+		name   string
+		key    string
+		addr   string
+		want   string
+		reject bool
+	}{
+		{"用户规则直连", "applykey", "intranet.test:80", "direct", false},
+		{"用户规则优先于全局拒绝", "applykey", "allowed.blocked.test:443", "direct", false},
+		{"全局拒绝", "applykey", "www.blocked.test:443", "", true},
+		{"全局命名路由", "applykey", "example.com:8080", `{"hops":[{"type":"socks5","addr":"100.64.0.1:1080"}]}`, false},
+		{"未命中使用用户路由", "applykey", "example.com:443", "user-route", false},
+		{"匿名访问只匹配全局规则", "", "intranet.test:80", "user-route", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyRules(context.Background(), tt.key, "user-route", tt.addr)
+			if tt.reject {
+				if !errors.Is(err, ErrNotAllowed) {
+					t.Errorf("期望 ErrNotAllowed，实际 %q, err=%v", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("期望 %s，实际 %s, err=%v", tt.want, got, err)
+			}
+		})
+	}
+}
+
+// TestRulesProxy 测试 SOCKS5 与 HTTP 代理按规则直连或拒绝目标
+func TestRulesProxy(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	// 用户路由指向不可达的节点，只有经直连规则才能连通回显服务
+	userProxyMapLock.Lock()
+	UserProxyMap["rulekey:rulekey"] = closedAddr(t)
+	userProxyMapLock.Unlock()
+	if err := SetRules([]Rule{{Domain: []string{"blocked.test"}, Action: ActionReject}}, nil); err != nil {
+		t.Fatalf("设置全局规则失败: %v", err)
+	}
+	defer SetRules(nil, nil)
+	if err := SetUserRules("rulekey", []Rule{{CIDR: []string{"127.0.0.0/8"}, Action: ActionDirect}}); err != nil {
+		t.Fatalf("设置用户规则失败: %v", err)
+	}
+	defer SetUserRules("rulekey", nil)
+
+	socksAddr := startTestSOCKS5Server(t)
+	socks5Connect := func(target string) byte {
+		t.Helper()
+		conn, err := net.Dial("tcp", socksAddr)
+		if err != nil {
+			t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		socks5TestLogin(t, conn, "rulekey", "rulekey")
+		dst, _ := encodeSOCKS5Addr(target)
+		conn.Write(append([]byte{SOCKS5Version, ConnectCmd, 0x00}, dst...))
+		rep, _, err := readSOCKS5Reply(conn)
+		if err != nil {
+			t.Fatalf("读取应答失败: %v", err)
+		}
+		return rep
+	}
+	if rep := socks5Connect(echoAddr); rep != RepSucceeded {
+		t.Errorf("SOCKS5 直连规则期望成功，实际应答码 %d", rep)
+	}
+	if rep := socks5Connect("www.blocked.test:443"); rep != RepNotAllowed {
+		t.Errorf("SOCKS5 拒绝规则期望应答码 %d，实际 %d", RepNotAllowed, rep)
+	}
+
+	httpAddr := startHTTPProxyServerWithOptions(t)
+	httpDial := func(target string) (net.Conn, error) {
+		t.Helper()
+		conn, err := net.Dial("tcp", httpAddr)
+		if err != nil {
+			t.Fatalf("无法连接到 HTTP 代理: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return httpConnect(conn, target, "rulekey", "rulekey")
+	}
+	tunnel, err := httpDial(echoAddr)
+	if err != nil {
+		t.Fatalf("HTTP 直连规则期望成功: %v", err)
+	}
+	tunnel.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(tunnel, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+	}
+	if _, err := httpDial("www.blocked.test:443"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("HTTP 拒绝规则期望 403，实际 %v", err)
+	}
+	t.Logf("✅ 规则直连与拒绝在 SOCKS5 与 HTTP 代理中生效")
+}
+
+// TestRulesBindAndUDP 测试 BIND 的 DST 与 UDP 数据报的目标地址同样受拒绝规则约束
+func TestRulesBindAndUDP(t *testing.T) {
+	downstream := startFakeUDPDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["ruleudp:ruleudp"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+	if err := SetUserRules("ruleudp", []Rule{{CIDR: []string{"10.0.0.0/8"}, Action: ActionReject}}); err != nil {
+		t.Fatalf("设置用户规则失败: %v", err)
+	}
+	defer SetUserRules("ruleudp", nil)
+	socksAddr := startTestSOCKS5Server(t)
+	request := func(cmd byte, dst string) (net.Conn, byte, string) {
+		t.Helper()
+		conn, err := net.Dial("tcp", socksAddr)
+		if err != nil {
+			t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		socks5TestLogin(t, conn, "ruleudp", "ruleudp")
+		addr, _ := encodeSOCKS5Addr(dst)
+		conn.Write(append([]byte{SOCKS5Version, cmd, 0x00}, addr...))
+		rep, bnd, err := readSOCKS5Reply(conn)
+		if err != nil {
+			t.Fatalf("读取应答失败: %v", err)
+		}
+		return conn, rep, bnd
+	}
+
+	if _, rep, _ := request(BindCmd, "10.1.2.3:0"); rep != RepNotAllowed {
+		t.Errorf("BIND 拒绝规则期望应答码 %d，实际 %d", RepNotAllowed, rep)
+	}
+
+	_, rep, relayAddr := request(UDPAssociateCmd, "0.0.0.0:0")
+	if rep != RepSucceeded {
+		t.Fatalf("期望 UDP ASSOCIATE 成功，实际应答码 %d", rep)
+	}
+	udpConn, err := net.Dial("udp", relayAddr)
+	if err != nil {
+		t.Fatalf("连接 UDP 中继失败: %v", err)
+	}
+	defer udpConn.Close()
+	udpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	// 发往被拒绝网段的数据报应被丢弃，只有后一个数据报得到回显
+	for _, target := range []string{"10.1.2.3:53", "8.8.8.8:53"} {
+		pkt, _ := buildUDPDatagram(target, []byte(target))
+		udpConn.Write(pkt)
+	}
+	buf := make([]byte, maxUDPPacketSize)
+	n, err := udpConn.Read(buf)
+	if err != nil {
+		t.Fatalf("读取回显数据报失败: %v", err)
+	}
+	if from, _, _ := parseUDPDatagram(buf[:n]); from != "8.8.8.8:53" {
+		t.Errorf("期望只收到 8.8.8.8:53 的回显，实际 %s", from)
+	}
+}
+
+// TestUDPRelayVerdictCache 测试 UDP 会话内按目标地址缓存规则判定，规则只在首个数据报时匹配
+func TestUDPRelayVerdictCache(t *testing.T) {
+	defer SetUserRules("verdictkey", nil)
+	r := &udpRelay{key: "verdictkey", route: "socks5://127.0.0.1:1080"}
+	if !r.allowed("10.1.2.3:53") {
+		t.Fatalf("没有规则时应放行")
+	}
+	if err := SetUserRules("verdictkey", []Rule{{CIDR: []string{"10.0.0.0/8"}, Action: ActionReject}}); err != nil {
+		t.Fatalf("设置用户规则失败: %v", err)
+	}
+	if !r.allowed("10.1.2.3:53") {
+		t.Errorf("同一会话内应沿用首次判定")
+	}
+	if r.allowed("10.4.5.6:53") {
+		t.Errorf("新的目标地址应按当前规则判定")
+	}
+}
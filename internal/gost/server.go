@@ -0,0 +1,134 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed 由 Serve 在服务关闭（Shutdown 或 ctx 取消）后返回。
+var ErrServerClosed = errors.New("gost: server closed")
+
+// serverState 跟踪代理服务的监听器与活跃会话，
+// 实现"停止接收 -> 排空会话 -> 超时强制关闭"的优雅关闭流程。零值可直接使用。
+type serverState struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	sessions  map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	shutdown  bool
+}
+
+// serve 在 ln 上循环接受连接并交给 handle 处理，直到 ctx 取消或服务关闭。
+func (st *serverState) serve(ctx context.Context, ln net.Listener, handle func(net.Conn)) error {
+	if !st.addListener(ln) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer st.removeListener(ln)
+	// ctx 取消时关闭监听器，使 Accept 返回
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || st.isShutdown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Printf("Accept error: %v", err)
+			continue
+		}
+		// 每个连接独立 goroutine 处理，防止阻塞主循环
+		go handle(conn)
+	}
+}
+
+// addListener 登记监听器，服务已关闭时返回 false。
+func (st *serverState) addListener(ln net.Listener) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.shutdown {
+		return false
+	}
+	if st.listeners == nil {
+		st.listeners = make(map[net.Listener]struct{})
+	}
+	st.listeners[ln] = struct{}{}
+	return true
+}
+
+func (st *serverState) removeListener(ln net.Listener) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.listeners, ln)
+}
+
+// addSession 登记一个活跃会话，服务关闭中返回 false，调用方应直接关闭连接。
+func (st *serverState) addSession(conn net.Conn) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.shutdown {
+		return false
+	}
+	if st.sessions == nil {
+		st.sessions = make(map[net.Conn]struct{})
+	}
+	st.sessions[conn] = struct{}{}
+	st.wg.Add(1)
+	return true
+}
+
+// removeSession 注销会话，须与成功的 addSession 成对调用。
+func (st *serverState) removeSession(conn net.Conn) {
+	st.mu.Lock()
+	delete(st.sessions, conn)
+	st.mu.Unlock()
+	st.wg.Done()
+}
+
+// activeSessions 返回当前活跃会话数。
+func (st *serverState) activeSessions() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.sessions)
+}
+
+func (st *serverState) isShutdown() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.shutdown
+}
+
+// drain 停止接收新连接并等待活跃会话结束。
+// ctx 到期时强制关闭剩余会话并立即返回 ctx.Err()，不再等待各会话的处理 goroutine 退出。
+func (st *serverState) drain(ctx context.Context) error {
+	st.mu.Lock()
+	st.shutdown = true
+	for ln := range st.listeners {
+		ln.Close()
+	}
+	st.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		st.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	st.mu.Lock()
+	log.Printf("排空超时，强制关闭剩余 %d 个会话", len(st.sessions))
+	for conn := range st.sessions {
+		conn.Close()
+	}
+	st.mu.Unlock()
+	return ctx.Err()
+}
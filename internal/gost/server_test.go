@@ -0,0 +1,109 @@
+package gost
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSOCKS5ServerShutdown 测试 SOCKS5 服务的优雅关闭：停止接收、排空超时后强制关闭隧道
+func TestSOCKS5ServerShutdown(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["drain:drain"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+
+	server := NewSOCKS5Server(":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(context.Background(), listener) }()
+
+	// 建立一条长连接隧道
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	socks5TestLogin(t, conn, "drain", "drain")
+	dst, _ := encodeSOCKS5Addr(echoAddr)
+	conn.Write(append([]byte{0x05, ConnectCmd, 0x00}, dst...))
+	if rep, _, err := readSOCKS5Reply(conn); err != nil || rep != RepSucceeded {
+		t.Fatalf("SOCKS5 CONNECT 失败: rep=%d, err=%v", rep, err)
+	}
+	if n := server.ActiveSessions(); n != 1 {
+		t.Fatalf("期望 1 个活跃会话，实际 %d", n)
+	}
+
+	// 排空期限很短，隧道仍在使用，应被强制关闭
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("期望 Shutdown 返回 DeadlineExceeded，实际 %v", err)
+	}
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("期望 Serve 返回 ErrServerClosed，实际 %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err == nil {
+		t.Errorf("期望隧道已被强制关闭")
+	}
+	// 关闭后不再接受新连接
+	if _, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second); err == nil {
+		t.Errorf("期望监听器已关闭")
+	}
+}
+
+// TestHTTPProxyServerShutdown 测试 HTTP 代理服务在无活跃隧道时立即完成关闭，有隧道时等待其结束
+func TestHTTPProxyServerShutdown(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["drainhttp:drainhttp"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+
+	server := NewHTTPProxyServer(":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(context.Background(), listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接 HTTP 代理失败: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "CONNECT " + echoAddr + " HTTP/1.1\r\nHost: " + echoAddr + "\r\n" +
+		"Proxy-Authorization: Basic " + base64EncodeString("drainhttp:drainhttp") + "\r\n\r\n"
+	conn.Write([]byte(req))
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("HTTP CONNECT 失败: resp=%v, err=%v", resp, err)
+	}
+
+	// 客户端稍后主动结束隧道，Shutdown 应在期限内正常返回
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("期望 Shutdown 正常返回，实际 %v", err)
+	}
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("期望 Serve 返回 ErrServerClosed，实际 %v", err)
+	}
+	if n := server.ActiveSessions(); n != 0 {
+		t.Errorf("期望活跃会话为 0，实际 %d", n)
+	}
+}
@@ -0,0 +1,264 @@
+package gost
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Shadowsocks AEAD 加密方式
+const (
+	CipherAES128GCM        = "aes-128-gcm"
+	CipherAES256GCM        = "aes-256-gcm"
+	CipherChacha20Poly1305 = "chacha20-ietf-poly1305"
+)
+
+// ssMaxPayload 为单个 AEAD 数据块的最大负载长度。
+const ssMaxPayload = 0x3FFF
+
+// ssCiphers 为支持的加密方式及其密钥长度。
+var ssCiphers = map[string]int{
+	CipherAES128GCM:        16,
+	CipherAES256GCM:        32,
+	CipherChacha20Poly1305: 32,
+}
+
+// ShadowsocksDialer 经 Shadowsocks（AEAD）服务器建立连接，可作为代理链的任意一层。
+type ShadowsocksDialer struct {
+	Addr     string // 服务器地址 host:port
+	Cipher   string // aes-128-gcm、aes-256-gcm 或 chacha20-ietf-poly1305
+	Password string
+	Timeout  time.Duration // 与该层完成握手的超时，0 表示不单独限制
+}
+
+// DialContext 连接 Shadowsocks 服务器并建立到 addr 的连接。
+func (d *ShadowsocksDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialHops(ctx, network, addr, d)
+}
+
+// HopAddr 返回服务器地址。
+func (d *ShadowsocksDialer) HopAddr() string { return d.Addr }
+
+func (d *ShadowsocksDialer) hopTimeout() time.Duration { return d.Timeout }
+
+// Tunnel 以加密流包装 conn 并发送目标地址。
+// Shadowsocks 没有握手应答，服务器无法连接目标时在之后的读写中表现为连接关闭。
+func (d *ShadowsocksDialer) Tunnel(conn net.Conn, addr string) (net.Conn, error) {
+	c, err := newSSCipher(d.Cipher, d.Password)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := encodeSOCKS5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+	ss := newSSConn(conn, c)
+	if _, err := ss.Write(dst); err != nil {
+		return nil, newHopError(d.Addr, err)
+	}
+	return ss, nil
+}
+
+// ssCipher 为由密码派生的主密钥及对应的 AEAD 构造函数。
+type ssCipher struct {
+	key     []byte
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+func newSSCipher(method, password string) (*ssCipher, error) {
+	keyLen, ok := ssCiphers[strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("不支持的 Shadowsocks 加密方式: %q", method)
+	}
+	if password == "" {
+		return nil, errors.New("Shadowsocks 密码不能为空")
+	}
+	c := &ssCipher{key: evpBytesToKey(password, keyLen)}
+	if strings.ToLower(method) == CipherChacha20Poly1305 {
+		c.newAEAD = chacha20poly1305.New
+	} else {
+		c.newAEAD = func(key []byte) (cipher.AEAD, error) {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return cipher.NewGCM(block)
+		}
+	}
+	return c, nil
+}
+
+// aead 返回以 salt 派生的会话子密钥（HKDF-SHA1，info 为 "ss-subkey"）初始化的 AEAD。
+func (c *ssCipher) aead(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, len(c.key))
+	if _, err := io.ReadFull(hkdf.New(sha1.New, c.key, salt, []byte("ss-subkey")), subkey); err != nil {
+		return nil, err
+	}
+	return c.newAEAD(subkey)
+}
+
+// evpBytesToKey 按 OpenSSL EVP_BytesToKey（MD5，无盐）由密码派生主密钥。
+func evpBytesToKey(password string, keyLen int) []byte {
+	var key, prev []byte
+	for len(key) < keyLen {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:keyLen]
+}
+
+// ssConn 为 Shadowsocks AEAD 加密流：每个方向以随机 salt 开头，
+// 之后为若干 [加密的 2 字节长度][加密的负载] 数据块，nonce 为从 0 开始逐次递增的小端计数。
+type ssConn struct {
+	net.Conn
+	cipher   *ssCipher
+	enc, dec cipher.AEAD
+	encNonce []byte
+	decNonce []byte
+	pending  []byte // 已解密、尚未读取的负载
+}
+
+func newSSConn(conn net.Conn, c *ssCipher) *ssConn {
+	return &ssConn{Conn: conn, cipher: c}
+}
+
+// Write 加密 b 并写入底层连接，首次写入时先发送 salt。
+func (c *ssConn) Write(b []byte) (int, error) {
+	var out []byte
+	if c.enc == nil {
+		salt := make([]byte, len(c.cipher.key))
+		if _, err := rand.Read(salt); err != nil {
+			return 0, err
+		}
+		aead, err := c.cipher.aead(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.enc, c.encNonce = aead, make([]byte, aead.NonceSize())
+		out = salt
+	}
+	for p := b; len(p) > 0; {
+		n := min(len(p), ssMaxPayload)
+		out = c.seal(out, []byte{byte(n >> 8), byte(n)})
+		out = c.seal(out, p[:n])
+		p = p[n:]
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *ssConn) seal(dst, plaintext []byte) []byte {
+	dst = c.enc.Seal(dst, c.encNonce, plaintext, nil)
+	incNonce(c.encNonce)
+	return dst
+}
+
+// Read 读取并解密下一个数据块，首次读取时先读取对端的 salt。
+func (c *ssConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if err := c.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *ssConn) readChunk() error {
+	if c.dec == nil {
+		salt := make([]byte, len(c.cipher.key))
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return err
+		}
+		aead, err := c.cipher.aead(salt)
+		if err != nil {
+			return err
+		}
+		c.dec, c.decNonce = aead, make([]byte, aead.NonceSize())
+	}
+	overhead := c.dec.Overhead()
+	buf := make([]byte, 2+overhead)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return err
+	}
+	size, err := c.open(buf)
+	if err != nil {
+		return err
+	}
+	n := (int(size[0])<<8 | int(size[1])) & ssMaxPayload
+	buf = make([]byte, n+overhead)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return unexpectedEOF(err)
+	}
+	c.pending, err = c.open(buf)
+	return err
+}
+
+func (c *ssConn) open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.dec.Open(ciphertext[:0], c.decNonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("shadowsocks: 数据解密失败，加密方式或密码不匹配")
+	}
+	incNonce(c.decNonce)
+	return plaintext, nil
+}
+
+// incNonce 将小端计数的 nonce 加一。
+func incNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// unexpectedEOF 将数据块中途的 io.EOF 转换为 io.ErrUnexpectedEOF。
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseSIP002 解析 SIP002 格式的 userinfo：base64url(method:password)，或百分号编码的 method:password。
+func parseSIP002(u *url.URL) (string, string, error) {
+	if u.User == nil {
+		return "", "", errors.New("ss 地址缺少加密方式与密码")
+	}
+	if password, ok := u.User.Password(); ok {
+		return u.User.Username(), password, nil
+	}
+	s := u.User.Username()
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "=")); err != nil {
+			return "", "", fmt.Errorf("ss 地址的 userinfo 无效: %w", err)
+		}
+	}
+	method, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", errors.New("ss 地址的 userinfo 应为 method:password")
+	}
+	return method, password, nil
+}
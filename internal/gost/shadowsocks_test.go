@@ -0,0 +1,126 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestSSServer 启动进程内的 Shadowsocks AEAD 服务器：解密目标地址后直接连接目标并双向转发
+func startTestSSServer(t *testing.T, method, password string) string {
+	t.Helper()
+	c, err := newSSCipher(method, password)
+	if err != nil {
+		t.Fatalf("创建加密方式失败: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				ss := newSSConn(conn, c)
+				atyp := make([]byte, 1)
+				if _, err := io.ReadFull(ss, atyp); err != nil {
+					return
+				}
+				target, err := readSOCKS5Addr(ss, atyp[0])
+				if err != nil {
+					return
+				}
+				peer, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer peer.Close()
+				go io.Copy(peer, ss)
+				io.Copy(ss, peer)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// echoRoundTrip 经 conn 发送 payload 并读取等长的回显
+func echoRoundTrip(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	go conn.Write(payload)
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("回显内容不一致")
+	}
+}
+
+// TestShadowsocksDialer 测试各 AEAD 加密方式经 Shadowsocks 服务器建连
+func TestShadowsocksDialer(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	for _, method := range []string{CipherAES128GCM, CipherAES256GCM, CipherChacha20Poly1305} {
+		t.Run(method, func(t *testing.T) {
+			d := &ShadowsocksDialer{Addr: startTestSSServer(t, method, "secret"), Cipher: method, Password: "secret"}
+			conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("建连失败: %v", err)
+			}
+			defer conn.Close()
+			echoRoundTrip(t, conn, []byte("ping"))
+			// 超过单个数据块上限的负载被拆分为多个数据块
+			echoRoundTrip(t, conn, bytes.Repeat([]byte("0123456789"), 5000))
+		})
+	}
+
+	t.Run("密码不匹配", func(t *testing.T) {
+		d := &ShadowsocksDialer{Addr: startTestSSServer(t, CipherAES256GCM, "secret"), Cipher: CipherAES256GCM, Password: "wrong"}
+		conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("建连失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("ping"))
+		if _, err := conn.Read(make([]byte, 4)); err == nil {
+			t.Errorf("密码不匹配时服务器应关闭连接")
+		}
+	})
+	t.Logf("✅ Shadowsocks AEAD 加密方式均可建连")
+}
+
+// TestShadowsocksChain 测试 ss 节点作为代理链的第一层与最后一层
+func TestShadowsocksChain(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	ssAddr := startTestSSServer(t, CipherChacha20Poly1305, "secret")
+	socksAddr := startFakeSOCKS5WithBND(t, "127.0.0.1:0")
+	ssURL := "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzZWNyZXQ@" + ssAddr // chacha20-ietf-poly1305:secret
+
+	for name, route := range map[string]string{
+		"单层":           ssURL,
+		"ss -> socks5": ssURL + " -> socks5://" + socksAddr,
+		"socks5 -> ss": "socks5://" + socksAddr + " -> " + ssURL,
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, err := ParseRoute(route)
+			if err != nil {
+				t.Fatalf("解析路由失败: %v", err)
+			}
+			conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("建连失败: %v", err)
+			}
+			defer conn.Close()
+			echoRoundTrip(t, conn, []byte("hello over shadowsocks"))
+		})
+	}
+}
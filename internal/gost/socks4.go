@@ -0,0 +1,143 @@
+package gost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS4 协议常量
+const (
+	SOCKS4Version  = 0x04 // SOCKS4 协议版本
+	SOCKS4Granted  = 0x5A // 请求已允许
+	SOCKS4Rejected = 0x5B // 请求被拒绝或失败
+)
+
+// socks4MaxFieldLen 为 USERID 与 SOCKS4a 域名字段的最大长度。
+const socks4MaxFieldLen = 255
+
+// handleSOCKS4 处理 SOCKS4/SOCKS4a CONNECT 请求。
+// USERID 字段作为注册 key（可携带用户名参数，见 ParseUsername），按 key:key 在 UserProxyMap 中查找下游代理，
+// 与 SOCKS5 用户名密码认证使用相同的路由；USERID 为空时适用匿名访问策略。
+// 参数 conn 为客户端连接，首字节为 0x04。
+func (s *SOCKS5Server) handleSOCKS4(conn net.Conn) {
+	cmd, targetAddr, userID, err := s.readSOCKS4Request(conn)
+	if err != nil {
+		log.Printf("SOCKS4 request error: %v", err)
+		writeSOCKS4Reply(conn, SOCKS4Rejected, "")
+		return
+	}
+	if cmd != ConnectCmd {
+		log.Printf("Unsupported SOCKS4 command: %d", cmd)
+		writeSOCKS4Reply(conn, SOCKS4Rejected, "")
+		return
+	}
+	// 1. USERID 即用户名（可携带用户名参数），SOCKS4 没有密码字段，以其中的注册 key 作为密码，
+	// 与 SOCKS5、HTTP 认证经相同的查找逻辑；空 USERID 按匿名策略选择路由
+	var proxyAddr string
+	var params UserParams
+	if userID == "" {
+		proxyAddr = s.opts.anonymous.Route(conn.RemoteAddr().String())
+	} else {
+		key, _ := ParseUsername(userID)
+		proxyAddr, params = s.authenticate(userID, key)
+	}
+	if proxyAddr == "" {
+		log.Printf("SOCKS4 authentication failed for user: %s", userID)
+		writeSOCKS4Reply(conn, SOCKS4Rejected, "")
+		return
+	}
+	log.Printf("SOCKS4 user %s authenticated, using proxy: %s", userID, proxyAddr)
+	// 2. 按路由规则为目标地址选择路由，通过下游代理建立到目标地址的连接
+	ctx := contextWithProxyHeader(context.Background(), s.opts.proxyHeaderOut, conn.RemoteAddr(), conn.LocalAddr())
+	if proxyAddr, err = applyRules(ctx, params.Key, proxyAddr, targetAddr); err != nil {
+		log.Printf("SOCKS4 connection to %s refused: %v", targetAddr, err)
+		writeSOCKS4Reply(conn, SOCKS4Rejected, "")
+		return
+	}
+	dialer, err := DialerFor(proxyAddr)
+	if err != nil {
+		log.Printf("DialerFor error: %v", err)
+		writeSOCKS4Reply(conn, SOCKS4Rejected, "")
+		return
+	}
+	proxyConn, err := dialer.DialContext(withUserParams(ctx, params), "tcp", targetAddr)
+	if err != nil {
+		log.Printf("Failed to connect to proxy %s: %v", proxyAddr, err)
+		writeSOCKS4Reply(conn, SOCKS4Rejected, "")
+		return
+	}
+	defer proxyConn.Close()
+	// 3. 通知客户端连接建立成功并开始转发
+	if err := writeSOCKS4Reply(conn, SOCKS4Granted, proxyConn.LocalAddr().String()); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	s.relay(conn, proxyConn)
+}
+
+// readSOCKS4Request 解析 SOCKS4/SOCKS4a 请求：VN + CD + DSTPORT + DSTIP + USERID + NULL [+ DOMAIN + NULL]。
+// DSTIP 为 0.0.0.x（x 非 0）时为 SOCKS4a，目标域名紧随 USERID 之后。
+// 返回值：命令字、目标地址（host:port）、USERID、error。
+func (s *SOCKS5Server) readSOCKS4Request(conn net.Conn) (byte, string, string, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return 0, "", "", err
+	}
+	if buf[0] != SOCKS4Version {
+		return 0, "", "", io.ErrUnexpectedEOF
+	}
+	cmd := buf[1]
+	port := int(buf[2])<<8 + int(buf[3])
+	ip := net.IP(buf[4:8])
+	userID, err := readNullTerminated(conn)
+	if err != nil {
+		return 0, "", "", err
+	}
+	host := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		// SOCKS4a：由代理解析域名
+		if host, err = readNullTerminated(conn); err != nil {
+			return 0, "", "", err
+		}
+	}
+	return cmd, net.JoinHostPort(host, strconv.Itoa(port)), userID, nil
+}
+
+// readNullTerminated 逐字节读取以 0x00 结尾的字段，避免多读客户端紧随其后发送的数据。
+// 字段超出 socks4MaxFieldLen 时返回错误。
+func readNullTerminated(r io.Reader) (string, error) {
+	var field []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0x00 {
+			return string(field), nil
+		}
+		if len(field) >= socks4MaxFieldLen {
+			return "", fmt.Errorf("SOCKS4 field too long")
+		}
+		field = append(field, b[0])
+	}
+}
+
+// writeSOCKS4Reply 向客户端写入 SOCKS4 应答：VN(0) + CD + DSTPORT + DSTIP。
+// addr 为非 IPv4 地址或为空时以 0.0.0.0:0 作为绑定地址。
+func writeSOCKS4Reply(w io.Writer, code byte, addr string) error {
+	reply := []byte{0x00, code, 0, 0, 0, 0, 0, 0}
+	if host, portStr, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host).To4(); ip != nil {
+			port, _ := strconv.Atoi(portStr)
+			reply[2], reply[3] = byte(port>>8), byte(port&0xff)
+			copy(reply[4:], ip)
+		}
+	}
+	_, err := w.Write(reply)
+	return err
+}
@@ -0,0 +1,76 @@
+package gost
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSOCKS4Connect 测试 SOCKS4 与 SOCKS4a CONNECT，USERID 作为注册 key
+func TestSOCKS4Connect(t *testing.T) {
+	// 回显目标
+	echoAddr := startEchoServer(t)
+	_, portStr, _ := net.SplitHostPort(echoAddr)
+	port, _ := strconv.Atoi(portStr)
+
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["socks4key:socks4key"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+	serverAddr := startTestSOCKS5Server(t)
+
+	request := func(userID string, ip []byte, domain string) net.Conn {
+		conn, err := net.Dial("tcp", serverAddr)
+		if err != nil {
+			t.Fatalf("无法连接到 SOCKS 服务器: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		req := []byte{SOCKS4Version, ConnectCmd, byte(port >> 8), byte(port & 0xff)}
+		req = append(req, ip...)
+		req = append(req, userID...)
+		req = append(req, 0x00)
+		if domain != "" {
+			req = append(req, domain...)
+			req = append(req, 0x00)
+		}
+		conn.Write(req)
+		return conn
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		ip     []byte
+		domain string
+		want   byte
+	}{
+		{"SOCKS4", "socks4key", []byte{127, 0, 0, 1}, "", SOCKS4Granted},
+		{"SOCKS4a", "socks4key", []byte{0, 0, 0, 1}, "localhost", SOCKS4Granted},
+		{"携带用户名参数", "socks4key-session-abc", []byte{127, 0, 0, 1}, "", SOCKS4Granted},
+		{"未注册的USERID", "unknown", []byte{127, 0, 0, 1}, "", SOCKS4Rejected},
+		{"未注册的key携带参数", "unknown-session-abc", []byte{127, 0, 0, 1}, "", SOCKS4Rejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := request(tt.userID, tt.ip, tt.domain)
+			defer conn.Close()
+			reply := make([]byte, 8)
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				t.Fatalf("读取 SOCKS4 应答失败: %v", err)
+			}
+			if reply[0] != 0x00 || reply[1] != tt.want {
+				t.Fatalf("期望应答码 %d，实际 %v", tt.want, reply)
+			}
+			if tt.want != SOCKS4Granted {
+				return
+			}
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+			}
+		})
+	}
+}
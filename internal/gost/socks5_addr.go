@@ -0,0 +1,102 @@
+package gost
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// readSOCKS5Addr 按地址类型 addrType 从 r 中读取 SOCKS5 地址（DST/BND.ADDR + PORT）。
+// 返回值：host:port 形式的地址字符串、error。
+func readSOCKS5Addr(r io.Reader, addrType byte) (string, error) {
+	var host string
+	switch addrType {
+	case IPv4Addr:
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = net.IP(buf).String()
+	case DomainAddr:
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		domain := make([]byte, buf[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	case IPv6Addr:
+		buf := make([]byte, 16)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = net.IP(buf).String()
+	default:
+		return "", fmt.Errorf("%w: %d", ErrAddrTypeNotSupported, addrType)
+	}
+	// 读取端口
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	port := int(buf[0])<<8 + int(buf[1])
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// encodeSOCKS5Addr 将 host:port 编码为 SOCKS5 的 ATYP + ADDR + PORT 字节序列。
+// IP 地址按 IPv4/IPv6 编码，其余按域名编码。
+func encodeSOCKS5Addr(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("invalid port: %s", portStr)
+	}
+	var b []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append([]byte{IPv4Addr}, ip4...)
+		} else {
+			b = append([]byte{IPv6Addr}, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain too long: %s", host)
+		}
+		b = append([]byte{DomainAddr, byte(len(host))}, host...)
+	}
+	return append(b, byte(port>>8), byte(port&0xff)), nil
+}
+
+// writeSOCKS5Reply 向客户端写入 SOCKS5 应答：VER + REP + RSV + BND.ADDR + BND.PORT。
+// addr 为空或无法编码时以 0.0.0.0:0 作为绑定地址。
+func writeSOCKS5Reply(w io.Writer, rep byte, addr string) error {
+	bnd, err := encodeSOCKS5Addr(addr)
+	if err != nil {
+		bnd = []byte{IPv4Addr, 0, 0, 0, 0, 0, 0}
+	}
+	_, err = w.Write(append([]byte{SOCKS5Version, rep, 0x00}, bnd...))
+	return err
+}
+
+// readSOCKS5Reply 读取下游 SOCKS5 代理的应答，支持 IPv4/IPv6/域名三种变长 BND.ADDR。
+// 返回值：REP 应答码、BND 地址（host:port）、error。
+func readSOCKS5Reply(r io.Reader) (byte, string, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, "", err
+	}
+	if hdr[0] != SOCKS5Version {
+		return 0, "", fmt.Errorf("unexpected SOCKS version: %d", hdr[0])
+	}
+	bnd, err := readSOCKS5Addr(r, hdr[3])
+	if err != nil {
+		return 0, "", err
+	}
+	return hdr[1], bnd, nil
+}
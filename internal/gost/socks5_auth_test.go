@@ -219,8 +219,8 @@ func TestSOCKS5ProtocolCompliance(t *testing.T) {
 
 		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
 
-		// 发送错误的 SOCKS 版本
-		conn.Write([]byte{0x04, 0x01, 0x02}) // SOCKS4 版本
+		// 发送错误的 SOCKS 版本（SOCKS4 已支持，这里使用未定义的版本号）
+		conn.Write([]byte{0x06, 0x01, 0x02})
 
 		// 连接应该被关闭
 		buf := make([]byte, 10)
@@ -0,0 +1,217 @@
+package gost
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// bindAcceptTimeout 为 BIND 等待对端入站连接的最长时间。
+const bindAcceptTimeout = 2 * time.Minute
+
+// bindEarlyDataLimit 为等待对端期间暂存的客户端数据上限，超出时放弃本次 BIND。
+const bindEarlyDataLimit = 64 << 10
+
+// handleBind 处理 SOCKS5 BIND 请求，完成两次应答（监听地址、对端地址）后进入双向转发。
+// 路由的最后一层为 SOCKS5 代理（单层 SOCKS5 代理或代理链）时由该层执行 BIND，
+// 本服务只负责建立到该层的连接并透传两次应答；路由没有 SOCKS5 下游时
+// （直连、HTTP(S) 代理、注册节点、Shadowsocks、ssh、节点池与故障转移组）在本服务上监听。
+// 参数 ctx 携带发往下游的 PROXY protocol 头部，conn 为客户端连接，proxyAddr 为下游代理地址，
+// targetAddr 为客户端请求中的 DST（预期的对端地址）。
+func (s *SOCKS5Server) handleBind(ctx context.Context, conn net.Conn, proxyAddr, targetAddr string) {
+	d, err := DialerFor(proxyAddr)
+	if err != nil {
+		log.Printf("DialerFor error: %v", err)
+		writeSOCKS5Reply(conn, RepGeneralFailure, "")
+		return
+	}
+	base, hops, err := bindHops(d)
+	if err != nil {
+		log.Printf("BIND via %s failed: %v", proxyAddr, err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
+		return
+	}
+	if len(hops) == 0 {
+		s.handleLocalBind(conn, targetAddr)
+		return
+	}
+	s.handleRemoteBind(ctx, conn, proxyAddr, base, hops, targetAddr)
+}
+
+// bindHops 返回执行 BIND 所经的代理层级，最后一层为执行 BIND 的 SOCKS5 代理；
+// 路由没有 SOCKS5 下游时返回空，代理链最后一层不是 SOCKS5 代理时返回 ErrCommandNotSupported 分类的错误。
+func bindHops(d Dialer) (Dialer, []HopDialer, error) {
+	if t, ok := d.(*timeoutDialer); ok {
+		d = t.Dialer
+	}
+	switch d := d.(type) {
+	case *SOCKS5Dialer:
+		return nil, []HopDialer{d}, nil
+	case *ChainDialer:
+		last := d.Hops[len(d.Hops)-1]
+		if _, ok := last.(*SOCKS5Dialer); !ok {
+			return nil, nil, &DialError{Addr: last.HopAddr(), Kind: ErrCommandNotSupported, Err: fmt.Errorf("代理链最后一层不是 SOCKS5 代理，无法执行 BIND")}
+		}
+		return d.Base, d.Hops, nil
+	case *SSHDialer:
+		if d.Via != nil {
+			return nil, nil, &DialError{Addr: d.Addr, Kind: ErrCommandNotSupported, Err: fmt.Errorf("代理链最后一层不是 SOCKS5 代理，无法执行 BIND")}
+		}
+	}
+	return nil, nil, nil
+}
+
+// handleLocalBind 在控制连接的本地地址上监听，等待对端入站连接。
+func (s *SOCKS5Server) handleLocalBind(conn net.Conn, targetAddr string) {
+	var localIP net.IP
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = tcpAddr.IP
+	}
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localIP})
+	if err != nil {
+		log.Printf("BIND listen failed: %v", err)
+		writeSOCKS5Reply(conn, RepGeneralFailure, "")
+		return
+	}
+	defer ln.Close()
+	// 第一次应答：告知客户端监听地址
+	if err := writeSOCKS5Reply(conn, RepSucceeded, ln.Addr().String()); err != nil {
+		return
+	}
+	log.Printf("BIND listening on %s for %s", ln.Addr(), conn.RemoteAddr())
+	// 等待入站连接的期限由 bindAcceptTimeout 控制，清除握手期限
+	conn.SetDeadline(time.Time{})
+	// 客户端在等待期间断开时停止监听；提前发送的数据暂存，转发开始后交给对端
+	var early []byte
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			early = append(early, buf[:n]...)
+			if err != nil || len(early) > bindEarlyDataLimit {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					return
+				}
+				ln.Close()
+				return
+			}
+		}
+	}()
+	ln.SetDeadline(time.Now().Add(bindAcceptTimeout))
+	peer, err := ln.AcceptTCP()
+	// 停止监视客户端连接，之后的读取交给转发
+	conn.SetReadDeadline(time.Now())
+	<-watchDone
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("BIND accept failed: %v", err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
+		return
+	}
+	defer peer.Close()
+	// RFC 1928：DST.ADDR 用于校验入站连接的来源
+	if host, _, err := net.SplitHostPort(targetAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !ip.Equal(remoteIP(peer)) {
+			log.Printf("BIND peer %s does not match %s", peer.RemoteAddr(), targetAddr)
+			writeSOCKS5Reply(conn, RepNotAllowed, "")
+			return
+		}
+	}
+	// 第二次应答：告知客户端对端地址
+	if err := writeSOCKS5Reply(conn, RepSucceeded, peer.RemoteAddr().String()); err != nil {
+		return
+	}
+	if len(early) > 0 {
+		conn = &bufferedConn{Conn: conn, r: bufio.NewReader(io.MultiReader(bytes.NewReader(early), conn))}
+	}
+	s.relay(conn, peer)
+}
+
+// handleRemoteBind 建立到最后一层 SOCKS5 代理的连接并在该层执行 BIND，透传两次应答后进入双向转发。
+// 第一次应答须在节点超时与握手期限内返回，第二次应答（对端连入）最长等待 bindAcceptTimeout。
+func (s *SOCKS5Server) handleRemoteBind(ctx context.Context, conn net.Conn, proxyAddr string, base Dialer, hops []HopDialer, targetAddr string) {
+	if s.opts.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.handshakeTimeout)
+		defer cancel()
+	}
+	downstream, rep, bnd, err := dialRemoteBind(ctx, base, hops, targetAddr)
+	if err != nil {
+		log.Printf("BIND via %s failed: %v", proxyAddr, err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
+		return
+	}
+	defer downstream.Close()
+	if err := writeSOCKS5Reply(conn, rep, bnd); err != nil || rep != RepSucceeded {
+		return
+	}
+	// 等待对端连入的期限由 bindAcceptTimeout 控制，清除握手期限
+	conn.SetDeadline(time.Time{})
+	acceptCtx, cancel := context.WithTimeout(context.Background(), bindAcceptTimeout)
+	err = handshakeContext(acceptCtx, downstream, func() error {
+		var err error
+		rep, bnd, err = readSOCKS5Reply(downstream)
+		return err
+	})
+	cancel()
+	if err != nil {
+		log.Printf("BIND reply 2 from downstream failed: %v", err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
+		return
+	}
+	if err := writeSOCKS5Reply(conn, rep, bnd); err != nil || rep != RepSucceeded {
+		return
+	}
+	s.relay(conn, downstream)
+}
+
+// dialRemoteBind 经 base 与前序各层建立到最后一层 SOCKS5 代理的连接，按该层凭据认证并发送 BIND 请求，
+// 返回连接及该层的第一次应答。连接与握手受 ctx 及该层节点超时约束。
+func dialRemoteBind(ctx context.Context, base Dialer, hops []HopDialer, targetAddr string) (net.Conn, byte, string, error) {
+	last := hops[len(hops)-1].(*SOCKS5Dialer)
+	dst, err := encodeSOCKS5Addr(targetAddr)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	var conn net.Conn
+	if base == nil && len(hops) == 1 {
+		dialCtx, cancel := hopContext(ctx, last)
+		conn, err = dialHop(dialCtx, last.Addr)
+		cancel()
+	} else {
+		// 由前序各层建立到最后一层的隧道
+		conn, err = dialHopsVia(ctx, base, "tcp", last.Addr, hops[:len(hops)-1]...)
+	}
+	if err != nil {
+		return nil, 0, "", err
+	}
+	hctx, cancel := hopContext(ctx, last)
+	defer cancel()
+	var rep byte
+	var bnd string
+	err = handshakeContext(hctx, conn, func() error {
+		if err := socks5ClientHandshake(conn, last.Username, last.Password); err != nil {
+			return err
+		}
+		if _, err := conn.Write(append([]byte{SOCKS5Version, BindCmd, 0x00}, dst...)); err != nil {
+			return err
+		}
+		var err error
+		rep, bnd, err = readSOCKS5Reply(conn)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, 0, "", err
+	}
+	return conn, rep, bnd, nil
+}
@@ -0,0 +1,265 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestSOCKS5Server 在随机端口启动 SOCKS5Server，返回监听地址
+func startTestSOCKS5Server(t *testing.T) string {
+	t.Helper()
+	server := NewSOCKS5Server(":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleConnection(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// startSOCKS5ServerWithOptions 以指定选项启动 SOCKS5 服务器（经 Serve，含监听器包装），返回监听地址
+func startSOCKS5ServerWithOptions(t *testing.T, opts ...Option) string {
+	t.Helper()
+	server := NewSOCKS5Server(":0", opts...)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(context.Background(), listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return listener.Addr().String()
+}
+
+// socks5TestLogin 作为客户端完成 SOCKS5 用户名密码认证
+func socks5TestLogin(t *testing.T, conn net.Conn, username, password string) {
+	t.Helper()
+	conn.Write([]byte{0x05, 0x01, 0x02})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != 0x02 {
+		t.Fatalf("认证方法协商失败: %v, resp=%v", err, resp)
+	}
+	authReq := []byte{0x01, byte(len(username))}
+	authReq = append(authReq, username...)
+	authReq = append(authReq, byte(len(password)))
+	authReq = append(authReq, password...)
+	conn.Write(authReq)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != 0x00 {
+		t.Fatalf("认证失败: %v, resp=%v", err, resp)
+	}
+}
+
+// startFakeBindDownstream 启动一个最小化的下游 SOCKS5 代理，支持无认证的 CONNECT 与 BIND
+func startFakeBindDownstream(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00})
+				hdr := make([]byte, 4)
+				if _, err := io.ReadFull(conn, hdr); err != nil {
+					return
+				}
+				target, err := readSOCKS5Addr(conn, hdr[3])
+				if err != nil {
+					return
+				}
+				var peer net.Conn
+				switch hdr[1] {
+				case ConnectCmd:
+					peer, err = net.Dial("tcp", target)
+					if err != nil {
+						writeSOCKS5Reply(conn, 0x05, "")
+						return
+					}
+					writeSOCKS5Reply(conn, 0x00, peer.LocalAddr().String())
+				case BindCmd:
+					bl, err := net.Listen("tcp", "127.0.0.1:0")
+					if err != nil {
+						return
+					}
+					defer bl.Close()
+					writeSOCKS5Reply(conn, 0x00, bl.Addr().String())
+					peer, err = bl.Accept()
+					if err != nil {
+						return
+					}
+					writeSOCKS5Reply(conn, 0x00, peer.RemoteAddr().String())
+				default:
+					return
+				}
+				defer peer.Close()
+				go io.Copy(peer, conn)
+				io.Copy(conn, peer)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// testBindRoundTrip 发送 BIND 请求，模拟对端连入并校验两次应答与数据转发
+func testBindRoundTrip(t *testing.T, serverAddr, username, password string) {
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	socks5TestLogin(t, conn, username, password)
+
+	// BIND 请求，DST 为 0.0.0.0:0 表示不限制对端
+	conn.Write([]byte{0x05, BindCmd, 0x00, IPv4Addr, 0, 0, 0, 0, 0, 0})
+	rep, listenAddr, err := readSOCKS5Reply(conn)
+	if err != nil || rep != 0x00 {
+		t.Fatalf("第一次 BIND 应答异常: rep=%d, err=%v", rep, err)
+	}
+	t.Logf("✅ BIND 监听地址: %s", listenAddr)
+
+	// 模拟对端连入
+	peer, err := net.Dial("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("对端连接监听地址失败: %v", err)
+	}
+	defer peer.Close()
+	rep, peerAddr, err := readSOCKS5Reply(conn)
+	if err != nil || rep != 0x00 {
+		t.Fatalf("第二次 BIND 应答异常: rep=%d, err=%v", rep, err)
+	}
+	if peerAddr != peer.LocalAddr().String() {
+		t.Errorf("期望对端地址 %s，实际 %s", peer.LocalAddr(), peerAddr)
+	}
+
+	// 对端 -> 客户端 数据转发
+	peer.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("期望收到 hello，实际 %q, err=%v", buf, err)
+	}
+	// 客户端 -> 对端 数据转发
+	conn.Write([]byte("world"))
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "world" {
+		t.Errorf("对端期望收到 world，实际 %q, err=%v", buf, err)
+	}
+}
+
+// TestSOCKS5Bind 测试本地 BIND 与代理链 BIND
+func TestSOCKS5Bind(t *testing.T) {
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["binduser:bindpass"] = "127.0.0.1:8939"
+	UserProxyMap["chainbind:chainbind"] = "socks5://" + downstream + " -> socks5://" + downstream
+	UserProxyMap["socksbind:socksbind"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+	serverAddr := startTestSOCKS5Server(t)
+
+	t.Run("本地BIND", func(t *testing.T) {
+		testBindRoundTrip(t, serverAddr, "binduser", "bindpass")
+	})
+	t.Run("代理链BIND", func(t *testing.T) {
+		testBindRoundTrip(t, serverAddr, "chainbind", "chainbind")
+	})
+	t.Run("单层SOCKS5 BIND", func(t *testing.T) {
+		testBindRoundTrip(t, serverAddr, "socksbind", "socksbind")
+	})
+}
+
+// TestBindHops 测试 BIND 的执行位置：最后一层为 SOCKS5 代理时由该层执行，没有 SOCKS5 下游时在本服务上监听
+func TestBindHops(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		hops    int
+		wantErr bool
+	}{
+		{"单层SOCKS5", "socks5://127.0.0.1:1080", 1, false},
+		{"代理链", "http://127.0.0.1:8080 -> socks5://127.0.0.1:1080", 2, false},
+		{"注册节点", "127.0.0.1:8939", 0, false},
+		{"HTTP代理", "http://127.0.0.1:8080", 0, false},
+		{"直连", "direct", 0, false},
+		{"最后一层为HTTP", "socks5://127.0.0.1:1080 -> http://127.0.0.1:8080", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := DialerFor(tt.route)
+			if err != nil {
+				t.Fatalf("创建 Dialer 失败: %v", err)
+			}
+			_, hops, err := bindHops(d)
+			if tt.wantErr {
+				if !errors.Is(err, ErrCommandNotSupported) {
+					t.Errorf("期望 ErrCommandNotSupported，实际 %v", err)
+				}
+				return
+			}
+			if err != nil || len(hops) != tt.hops {
+				t.Errorf("期望 %d 层，实际 %d 层, err=%v", tt.hops, len(hops), err)
+			}
+		})
+	}
+}
+
+// TestBindHopTimeout 测试下游 SOCKS5 代理不应答 BIND 时按节点超时返回失败应答，不会一直占用客户端连接
+func TestBindHopTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// 接受连接后不作任何应答
+			defer conn.Close()
+		}
+	}()
+	userProxyMapLock.Lock()
+	UserProxyMap["silentbind:silentbind"] = `{"hops":[{"type":"socks5","addr":"` + ln.Addr().String() + `","timeout":"200ms"}]}`
+	userProxyMapLock.Unlock()
+	serverAddr := startTestSOCKS5Server(t)
+
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	socks5TestLogin(t, conn, "silentbind", "silentbind")
+	conn.Write([]byte{0x05, BindCmd, 0x00, IPv4Addr, 0, 0, 0, 0, 0, 0})
+	rep, _, err := readSOCKS5Reply(conn)
+	if err != nil {
+		t.Fatalf("下游不应答时应在节点超时后返回应答: %v", err)
+	}
+	if rep == RepSucceeded {
+		t.Errorf("下游不应答时不应返回成功")
+	}
+}
@@ -0,0 +1,493 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxUDPPacketSize 为单个 UDP 数据报的最大长度。
+const maxUDPPacketSize = 64 * 1024
+
+// maxUDPVerdicts 为单个 UDP 会话缓存的目标地址规则判定数上限，超出后清空重新缓存。
+const maxUDPVerdicts = 4096
+
+// errUDPFragment 表示收到了分片（FRAG != 0）的数据报，本实现不支持分片重组，直接丢弃。
+var errUDPFragment = errors.New("SOCKS5 UDP fragmentation not supported")
+
+// udpUpstream 表示 UDP 数据报的下游出口。
+// WriteDatagram 将负载发往 target（host:port），ReadDatagram 读取下游返回的负载及其来源地址。
+type udpUpstream interface {
+	WriteDatagram(payload []byte, target string) error
+	ReadDatagram(buf []byte) (n int, from string, err error)
+	Close() error
+}
+
+// handleUDPAssociate 处理 SOCKS5 UDP ASSOCIATE 请求。
+// 为每个会话创建独立的 UDP 中继套接字，将客户端数据报解封装后经下游（注册节点或单层 SOCKS5 代理）转发，
+// 并把下游返回的数据报按 RFC 1928 重新封装后发回客户端。控制用 TCP 连接关闭时中继随之拆除。
+// 每个目标地址在会话内按注册 key 的路由规则匹配一次：被拒绝或规则选中了其他路由的数据报被丢弃，
+// 因为同一会话内的数据报只能经建立关联时的下游转发。
+// 参数 conn 为客户端控制连接，proxyAddr 为下游代理地址，params 为用户名参数（匿名访问时为零值），
+// clientAddr 为客户端声明的 UDP 发送地址（可为 0.0.0.0:0）。
+func (s *SOCKS5Server) handleUDPAssociate(conn net.Conn, proxyAddr string, params UserParams, clientAddr string) {
+	// 1. 与下游建立 UDP 关联，须在握手期限内完成
+	ctx := withUserParams(context.Background(), params)
+	if s.opts.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.handshakeTimeout)
+		defer cancel()
+	}
+	upstream, err := dialUDPUpstream(ctx, proxyAddr)
+	if err != nil {
+		log.Printf("UDP associate via %s failed: %v", proxyAddr, err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
+		return
+	}
+	defer upstream.Close()
+	// 2. 在控制连接的本地地址上创建中继套接字，保证返回给客户端的 BND.ADDR 可达
+	var localIP net.IP
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = tcpAddr.IP
+	}
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		log.Printf("UDP relay listen failed: %v", err)
+		writeSOCKS5Reply(conn, RepGeneralFailure, "")
+		return
+	}
+	defer relayConn.Close()
+	// 3. 返回中继地址
+	if err := writeSOCKS5Reply(conn, RepSucceeded, relayConn.LocalAddr().String()); err != nil {
+		return
+	}
+	log.Printf("UDP relay %s established for %s via %s", relayConn.LocalAddr(), conn.RemoteAddr(), proxyAddr)
+	// 控制连接此后仅用于感知客户端断开，不受握手期限约束
+	conn.SetDeadline(time.Time{})
+	// 4. 控制连接关闭时拆除中继
+	go func() {
+		io.Copy(io.Discard, conn)
+		relayConn.Close()
+		upstream.Close()
+	}()
+	relay := &udpRelay{
+		conn:     relayConn,
+		upstream: upstream,
+		clientIP: remoteIP(conn),
+		idle:     s.opts.idleTimeout,
+		key:      params.Key,
+		route:    proxyAddr,
+	}
+	if declared, err := net.ResolveUDPAddr("udp", clientAddr); err == nil && declared.Port != 0 {
+		if declared.IP != nil && !declared.IP.IsUnspecified() {
+			relay.clientIP = declared.IP
+		}
+		relay.clientPort = declared.Port
+	}
+	relay.serve()
+	log.Printf("UDP relay %s closed", relayConn.LocalAddr())
+}
+
+// udpRelay 表示单个 UDP ASSOCIATE 会话的中继状态。
+type udpRelay struct {
+	conn       *net.UDPConn
+	upstream   udpUpstream
+	clientIP   net.IP // 仅接受来自该 IP 的数据报
+	clientPort int    // 客户端声明的源端口，0 表示不限制
+	idle       time.Duration
+	key        string          // 匹配路由规则使用的注册 key
+	route      string          // 会话的下游路由，规则结果与之不同的数据报被丢弃
+	verdicts   map[string]bool // 目标地址的规则判定，会话内只匹配一次；仅由 clientToUpstream 访问
+
+	lastActive atomic.Int64 // 最近一次转发数据报的时间（UnixNano）
+	mu         sync.Mutex
+	clientAddr *net.UDPAddr // 最近一次收到客户端数据报的来源地址
+}
+
+// serve 启动双向转发，任一方向结束或双向空闲超过 idle 时关闭整个中继。
+func (r *udpRelay) serve() {
+	r.lastActive.Store(time.Now().UnixNano())
+	if r.idle > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go r.watchIdle(done)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.clientToUpstream()
+		r.upstream.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		r.upstreamToClient()
+		r.conn.Close()
+	}()
+	wg.Wait()
+}
+
+// watchIdle 周期检查中继活跃时间，空闲超过 idle 时关闭两端套接字使 serve 返回。
+func (r *udpRelay) watchIdle(done <-chan struct{}) {
+	ticker := time.NewTicker(r.idle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, r.lastActive.Load())) >= r.idle {
+				log.Printf("UDP relay %s idle for %s, closing", r.conn.LocalAddr(), r.idle)
+				r.conn.Close()
+				r.upstream.Close()
+				return
+			}
+		}
+	}
+}
+
+// clientToUpstream 读取客户端数据报，解封装后交给下游。
+func (r *udpRelay) clientToUpstream() {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		// 丢弃非会话客户端发来的数据报
+		if r.clientIP != nil && !from.IP.Equal(r.clientIP) {
+			continue
+		}
+		if r.clientPort != 0 && from.Port != r.clientPort {
+			continue
+		}
+		target, payload, err := parseUDPDatagram(buf[:n])
+		if err != nil {
+			log.Printf("UDP relay drop datagram from %s: %v", from, err)
+			continue
+		}
+		if !r.allowed(target) {
+			continue
+		}
+		r.lastActive.Store(time.Now().UnixNano())
+		r.mu.Lock()
+		r.clientAddr = from
+		r.mu.Unlock()
+		if err := r.upstream.WriteDatagram(payload, target); err != nil {
+			log.Printf("UDP relay write to %s failed: %v", target, err)
+			return
+		}
+	}
+}
+
+// allowed 按路由规则检查数据报的目标地址，只放行仍经会话下游转发的目标。
+// 判定结果在会话内按目标地址缓存，避免 QUIC、DNS 等流量逐个数据报匹配规则（可能触发域名解析）。
+func (r *udpRelay) allowed(target string) bool {
+	if ok, cached := r.verdicts[target]; cached {
+		return ok
+	}
+	ok := r.checkRules(target)
+	if r.verdicts == nil || len(r.verdicts) >= maxUDPVerdicts {
+		r.verdicts = make(map[string]bool)
+	}
+	r.verdicts[target] = ok
+	return ok
+}
+
+// checkRules 按路由规则匹配 target，判定是否经会话下游转发。
+func (r *udpRelay) checkRules(target string) bool {
+	route, err := applyRules(context.Background(), r.key, r.route, target)
+	if err != nil {
+		log.Printf("UDP relay drop datagrams to %s: %v", target, err)
+		return false
+	}
+	if route != r.route {
+		log.Printf("UDP relay drop datagrams to %s: rule selects route %s, session uses %s", target, route, r.route)
+		return false
+	}
+	return true
+}
+
+// upstreamToClient 读取下游返回的数据报，重新封装后发回客户端。
+func (r *udpRelay) upstreamToClient() {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, from, err := r.upstream.ReadDatagram(buf)
+		if err != nil {
+			return
+		}
+		r.lastActive.Store(time.Now().UnixNano())
+		r.mu.Lock()
+		clientAddr := r.clientAddr
+		r.mu.Unlock()
+		if clientAddr == nil {
+			continue
+		}
+		pkt, err := buildUDPDatagram(from, buf[:n])
+		if err != nil {
+			continue
+		}
+		if _, err := r.conn.WriteToUDP(pkt, clientAddr); err != nil {
+			return
+		}
+	}
+}
+
+// parseUDPDatagram 解析 RFC 1928 UDP 请求头：RSV(2) + FRAG(1) + ATYP + DST.ADDR + DST.PORT + DATA。
+// 返回值：目标地址、负载、error。
+func parseUDPDatagram(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	if b[2] != 0x00 {
+		return "", nil, errUDPFragment
+	}
+	r := bytes.NewReader(b[4:])
+	addr, err := readSOCKS5Addr(r, b[3])
+	if err != nil {
+		return "", nil, err
+	}
+	return addr, b[len(b)-r.Len():], nil
+}
+
+// buildUDPDatagram 按 RFC 1928 为负载添加 UDP 请求头。
+func buildUDPDatagram(addr string, payload []byte) ([]byte, error) {
+	hdr, err := encodeSOCKS5Addr(addr)
+	if err != nil {
+		return nil, err
+	}
+	pkt := make([]byte, 0, 3+len(hdr)+len(payload))
+	pkt = append(pkt, 0x00, 0x00, 0x00)
+	pkt = append(pkt, hdr...)
+	return append(pkt, payload...), nil
+}
+
+// remoteIP 返回连接对端的 IP 地址，无法解析时返回 nil。
+func remoteIP(conn net.Conn) net.IP {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// udpHopPicker 由可承载 UDP ASSOCIATE 的 Dialer 实现，返回依次尝试建立 UDP 关联的 SOCKS5 节点。
+// 节点池与故障转移组按与 TCP 建连相同的规则（标签筛选、会话固定、策略、冷却）排列成员，
+// 但 UDP 关联的成败不更新会话固定与冷却状态。
+type udpHopPicker interface {
+	udpHops(ctx context.Context) ([]*SOCKS5Dialer, error)
+}
+
+// udpHopsOf 返回经 d 转发 UDP 时依次尝试的 SOCKS5 节点，d 不支持 UDP 时返回 ErrCommandNotSupported 分类的错误。
+func udpHopsOf(ctx context.Context, d Dialer) ([]*SOCKS5Dialer, error) {
+	if p, ok := d.(udpHopPicker); ok {
+		return p.udpHops(ctx)
+	}
+	return nil, &DialError{Kind: ErrCommandNotSupported, Err: fmt.Errorf("直连、ssh 与 Shadowsocks 路由不支持 UDP")}
+}
+
+func (d *SOCKS5Dialer) udpHops(context.Context) ([]*SOCKS5Dialer, error) {
+	return []*SOCKS5Dialer{d}, nil
+}
+
+// udpHops 对注册节点返回同一端口上的 SOCKS5 服务，其余 HTTP 代理不支持 UDP。
+func (d *HTTPDialer) udpHops(context.Context) ([]*SOCKS5Dialer, error) {
+	if !d.Node {
+		return nil, &DialError{Addr: d.Addr, Kind: ErrCommandNotSupported, Err: fmt.Errorf("HTTP 代理不支持 UDP")}
+	}
+	return []*SOCKS5Dialer{{Addr: d.Addr, Username: d.Username, Password: d.Password, Timeout: d.Timeout}}, nil
+}
+
+// udpHops 仅支持单层代理：UDP 数据报无法经 TCP 隧道逐层转发，多层代理链若改由第一层出口会绕过其余层级，
+// 因此直接拒绝。
+func (d *ChainDialer) udpHops(ctx context.Context) ([]*SOCKS5Dialer, error) {
+	if d.Base != nil {
+		return nil, &DialError{Kind: ErrCommandNotSupported, Err: fmt.Errorf("经 ssh 节点的代理链不支持 UDP")}
+	}
+	if len(d.Hops) != 1 {
+		return nil, &DialError{Kind: ErrCommandNotSupported, Err: fmt.Errorf("多层代理链不支持 UDP")}
+	}
+	return udpHopsOf(ctx, d.Hops[0])
+}
+
+func (d *timeoutDialer) udpHops(ctx context.Context) ([]*SOCKS5Dialer, error) {
+	return udpHopsOf(ctx, d.Dialer)
+}
+
+// udpHops 按策略从筛选后的成员中选出首个尝试的成员，其余成员依次作为后备。
+func (d *PoolDialer) udpHops(ctx context.Context) ([]*SOCKS5Dialer, error) {
+	params := userParamsFromContext(ctx)
+	cands := d.filter(params.Labels)
+	if len(cands) == 0 {
+		return nil, &DialError{Kind: ErrNodeUnreachable, Err: fmt.Errorf("节点池中没有匹配 %v 的节点", params.Labels)}
+	}
+	start := -1
+	if params.Session != "" {
+		if name, ok := stickySessions.get(params.Key + "|" + params.Session); ok {
+			for k, i := range cands {
+				if d.Members[i].Name == name {
+					start = k
+					break
+				}
+			}
+		}
+	}
+	if start < 0 {
+		start = d.pick("", cands)
+	}
+	dialers := make([]Dialer, len(cands))
+	for k := range cands {
+		dialers[k] = d.Members[cands[(start+k)%len(cands)]].Dialer
+	}
+	return collectUDPHops(ctx, dialers)
+}
+
+// udpHops 按候选的冷却状态排列各候选的 SOCKS5 节点。
+func (d *FailoverDialer) udpHops(ctx context.Context) ([]*SOCKS5Dialer, error) {
+	order := d.order()
+	dialers := make([]Dialer, len(order))
+	for k, i := range order {
+		dialers[k] = d.Candidates[i].Dialer
+	}
+	return collectUDPHops(ctx, dialers)
+}
+
+// collectUDPHops 依次收集各成员的 SOCKS5 节点并跳过不支持 UDP 的成员，全部不支持时返回最后一个错误。
+func collectUDPHops(ctx context.Context, dialers []Dialer) ([]*SOCKS5Dialer, error) {
+	var hops []*SOCKS5Dialer
+	var lastErr error
+	for _, d := range dialers {
+		h, err := udpHopsOf(ctx, d)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		hops = append(hops, h...)
+	}
+	if len(hops) == 0 {
+		return nil, lastErr
+	}
+	return hops, nil
+}
+
+// socks5UDPUpstream 通过下游 SOCKS5 代理的 UDP ASSOCIATE 转发数据报。
+type socks5UDPUpstream struct {
+	ctrl net.Conn // 与下游代理的 TCP 控制连接，关闭即释放下游关联
+	conn net.Conn // 已连接到下游中继端口的 UDP 套接字
+}
+
+// dialUDPUpstream 按路由选出承载 UDP 的 SOCKS5 节点，依次尝试与其完成认证并建立 UDP 关联。
+// ctx 携带的用户名参数用于节点池的标签筛选与会话固定。
+func dialUDPUpstream(ctx context.Context, proxyAddr string) (udpUpstream, error) {
+	d, err := DialerFor(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	hops, err := udpHopsOf(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, hop := range hops {
+		up, err := associateUDP(ctx, hop)
+		if err == nil {
+			return up, nil
+		}
+		log.Printf("UDP associate via %s failed: %v", hop.Addr, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// associateUDP 与 SOCKS5 节点 hop 完成认证并建立 UDP 关联。
+// 连接与握手受 ctx 及节点超时（Timeout）约束，与 CONNECT 建连相同。
+func associateUDP(ctx context.Context, hop *SOCKS5Dialer) (udpUpstream, error) {
+	hctx, cancel := hopContext(ctx, hop)
+	defer cancel()
+	ctrl, err := dialNet(hctx, "tcp", hop.Addr)
+	if err != nil {
+		return nil, newHopError(hop.Addr, err)
+	}
+	var rep byte
+	var bnd string
+	err = handshakeContext(hctx, ctrl, func() error {
+		if err := socks5ClientHandshake(ctrl, hop.Username, hop.Password); err != nil {
+			return err
+		}
+		// 发送 UDP ASSOCIATE，源地址未知时填 0.0.0.0:0
+		if _, err := ctrl.Write([]byte{0x05, UDPAssociateCmd, 0x00, IPv4Addr, 0, 0, 0, 0, 0, 0}); err != nil {
+			return err
+		}
+		var err error
+		rep, bnd, err = readSOCKS5Reply(ctrl)
+		return err
+	})
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if rep != RepSucceeded {
+		ctrl.Close()
+		return nil, socks5RepError(hop.Addr, rep)
+	}
+	relayAddr, err := net.ResolveUDPAddr("udp", bnd)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	// 下游返回未指定地址时，使用控制连接的对端地址
+	if relayAddr.IP == nil || relayAddr.IP.IsUnspecified() {
+		relayAddr.IP = remoteIP(ctrl)
+	}
+	conn, err := dialNet(hctx, "udp", relayAddr.String())
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	up := &socks5UDPUpstream{ctrl: ctrl, conn: conn}
+	// 下游关闭控制连接时关联失效，同步关闭 UDP 套接字
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		conn.Close()
+	}()
+	return up, nil
+}
+
+// WriteDatagram 封装 SOCKS5 UDP 请求头后发送到下游中继。
+func (u *socks5UDPUpstream) WriteDatagram(payload []byte, target string) error {
+	pkt, err := buildUDPDatagram(target, payload)
+	if err != nil {
+		return err
+	}
+	_, err = u.conn.Write(pkt)
+	return err
+}
+
+// ReadDatagram 读取下游中继返回的数据报并解封装。
+func (u *socks5UDPUpstream) ReadDatagram(buf []byte) (int, string, error) {
+	pkt := make([]byte, maxUDPPacketSize)
+	for {
+		n, err := u.conn.Read(pkt)
+		if err != nil {
+			return 0, "", err
+		}
+		from, payload, err := parseUDPDatagram(pkt[:n])
+		if err != nil {
+			continue
+		}
+		return copy(buf, payload), from, nil
+	}
+}
+
+// Close 关闭 UDP 套接字与控制连接。
+func (u *socks5UDPUpstream) Close() error {
+	u.conn.Close()
+	return u.ctrl.Close()
+}
@@ -0,0 +1,232 @@
+package gost
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeUDPDownstream 启动一个最小化的下游 SOCKS5 代理，仅支持无认证 + UDP ASSOCIATE，
+// 其 UDP 中继端口原样回显收到的数据报（保留请求头），用于模拟注册节点。
+func startFakeUDPDownstream(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00})
+				hdr := make([]byte, 4)
+				if _, err := io.ReadFull(conn, hdr); err != nil || hdr[1] != UDPAssociateCmd {
+					return
+				}
+				if _, err := readSOCKS5Addr(conn, hdr[3]); err != nil {
+					return
+				}
+				udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					return
+				}
+				defer udp.Close()
+				bnd, _ := encodeSOCKS5Addr(udp.LocalAddr().String())
+				conn.Write(append([]byte{0x05, 0x00, 0x00}, bnd...))
+				go func() {
+					pkt := make([]byte, maxUDPPacketSize)
+					for {
+						n, from, err := udp.ReadFromUDP(pkt)
+						if err != nil {
+							return
+						}
+						udp.WriteToUDP(pkt[:n], from)
+					}
+				}()
+				io.Copy(io.Discard, conn)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestSOCKS5UDPAssociate 测试 UDP ASSOCIATE 经下游 SOCKS5 中继的完整往返，
+// 下游可以是 SOCKS5 代理、注册节点，或节点池与故障转移组中选出的成员
+func TestSOCKS5UDPAssociate(t *testing.T) {
+	downstream := startFakeUDPDownstream(t)
+	routes := map[string]string{
+		"SOCKS5代理": "socks5://" + downstream,
+		"节点池":      `{"pool":[{"direct":true},{"node":true,"hops":[{"type":"http","addr":"` + downstream + `"}]}]}`,
+		"故障转移组":    closedAddr(t) + " | " + downstream,
+	}
+	userProxyMapLock.Lock()
+	for name, route := range routes {
+		UserProxyMap["udp"+name+":udppass"] = route
+	}
+	userProxyMapLock.Unlock()
+	serverAddr := startTestSOCKS5Server(t)
+	for name := range routes {
+		t.Run(name, func(t *testing.T) {
+			testUDPRoundTrip(t, serverAddr, "udp"+name, "udppass")
+		})
+	}
+}
+
+// testUDPRoundTrip 建立 UDP 关联，经中继发送数据报并校验回显
+func testUDPRoundTrip(t *testing.T, serverAddr, username, password string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	socks5TestLogin(t, conn, username, password)
+
+	// UDP ASSOCIATE
+	conn.Write([]byte{0x05, UDPAssociateCmd, 0x00, IPv4Addr, 0, 0, 0, 0, 0, 0})
+	rep, relayAddr, err := readSOCKS5Reply(conn)
+	if err != nil {
+		t.Fatalf("读取 UDP ASSOCIATE 响应失败: %v", err)
+	}
+	if rep != RepSucceeded {
+		t.Fatalf("期望 UDP ASSOCIATE 成功，实际 REP=%d", rep)
+	}
+	t.Logf("✅ UDP 中继地址: %s", relayAddr)
+
+	// 发送数据报并等待回显
+	udpConn, err := net.Dial("udp", relayAddr)
+	if err != nil {
+		t.Fatalf("连接 UDP 中继失败: %v", err)
+	}
+	defer udpConn.Close()
+	udpConn.SetDeadline(time.Now().Add(5 * time.Second))
+	pkt, err := buildUDPDatagram("8.8.8.8:53", []byte("ping"))
+	if err != nil {
+		t.Fatalf("构造数据报失败: %v", err)
+	}
+	if _, err := udpConn.Write(pkt); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+	buf := make([]byte, maxUDPPacketSize)
+	n, err := udpConn.Read(buf)
+	if err != nil {
+		t.Fatalf("读取回显数据报失败: %v", err)
+	}
+	from, payload, err := parseUDPDatagram(buf[:n])
+	if err != nil {
+		t.Fatalf("解析回显数据报失败: %v", err)
+	}
+	if from != "8.8.8.8:53" || string(payload) != "ping" {
+		t.Errorf("期望 8.8.8.8:53/ping，实际 %s/%s", from, payload)
+	}
+}
+
+// TestUDPAssociateHopTimeout 测试下游节点不应答时 UDP 关联按节点超时或 ctx 期限失败，不会一直等待
+func TestUDPAssociateHopTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// 接受连接后不作任何应答
+			defer conn.Close()
+		}
+	}()
+	hopTimeoutCtx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		hop  *SOCKS5Dialer
+	}{
+		{"节点超时", context.Background(), &SOCKS5Dialer{Addr: ln.Addr().String(), Timeout: 200 * time.Millisecond}},
+		{"ctx 期限", hopTimeoutCtx, &SOCKS5Dialer{Addr: ln.Addr().String()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan error, 1)
+			go func() {
+				up, err := associateUDP(tt.ctx, tt.hop)
+				if err == nil {
+					up.Close()
+				}
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Errorf("下游不应答时应返回错误")
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("下游不应答时 UDP 关联未超时返回")
+			}
+		})
+	}
+}
+
+// TestChainUDPUnsupported 测试多层代理链拒绝 UDP，而不是改由第一层代理出口
+func TestChainUDPUnsupported(t *testing.T) {
+	hop := &SOCKS5Dialer{Addr: "127.0.0.1:1080"}
+	single := &ChainDialer{Hops: []HopDialer{hop}}
+	if hops, err := udpHopsOf(context.Background(), single); err != nil || len(hops) != 1 || hops[0] != hop {
+		t.Errorf("单层 SOCKS5 代理应支持 UDP，实际 %v, err=%v", hops, err)
+	}
+	chain := &ChainDialer{Hops: []HopDialer{hop, &SOCKS5Dialer{Addr: "127.0.0.1:1081"}}}
+	if _, err := udpHopsOf(context.Background(), chain); !errors.Is(err, ErrCommandNotSupported) {
+		t.Errorf("多层代理链期望 ErrCommandNotSupported，实际 %v", err)
+	}
+}
+
+// TestUDPDatagramCodec 测试 RFC 1928 UDP 请求头的编解码
+func TestUDPDatagramCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+	}{
+		{"IPv4", "1.2.3.4:53"},
+		{"IPv6", "[2001:db8::1]:443"},
+		{"域名", "example.com:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt, err := buildUDPDatagram(tt.addr, []byte("data"))
+			if err != nil {
+				t.Fatalf("buildUDPDatagram() error = %v", err)
+			}
+			addr, payload, err := parseUDPDatagram(pkt)
+			if err != nil {
+				t.Fatalf("parseUDPDatagram() error = %v", err)
+			}
+			if addr != tt.addr || !bytes.Equal(payload, []byte("data")) {
+				t.Errorf("parseUDPDatagram() = %s/%s, 期望 %s/data", addr, payload, tt.addr)
+			}
+		})
+	}
+
+	// 分片数据报应被拒绝
+	pkt, _ := buildUDPDatagram("1.2.3.4:53", []byte("x"))
+	pkt[2] = 0x01
+	if _, _, err := parseUDPDatagram(pkt); err != errUDPFragment {
+		t.Errorf("期望 errUDPFragment，实际 %v", err)
+	}
+}
@@ -1,35 +1,54 @@
 package gost
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"sync"
+	"time"
 )
 
 // SOCKS5 协议常量
 const (
-	SOCKS5Version = 0x05 // SOCKS5 协议版本
-	NoAuth        = 0x00 // 无需认证
-	UserPassAuth  = 0x02 // 用户名密码认证
-	ConnectCmd    = 0x01 // CONNECT 命令
-	IPv4Addr      = 0x01 // IPv4 地址类型
-	DomainAddr    = 0x03 // 域名地址类型
-	IPv6Addr      = 0x04 // IPv6 地址类型
+	SOCKS5Version   = 0x05 // SOCKS5 协议版本
+	NoAuth          = 0x00 // 无需认证
+	UserPassAuth    = 0x02 // 用户名密码认证
+	ConnectCmd      = 0x01 // CONNECT 命令
+	BindCmd         = 0x02 // BIND 命令
+	UDPAssociateCmd = 0x03 // UDP ASSOCIATE 命令
+	IPv4Addr        = 0x01 // IPv4 地址类型
+	DomainAddr      = 0x03 // 域名地址类型
+	IPv6Addr        = 0x04 // IPv6 地址类型
+)
+
+// SOCKS5 应答码（RFC 1928 REP 字段）
+const (
+	RepSucceeded            = 0x00 // 成功
+	RepGeneralFailure       = 0x01 // 普通 SOCKS 服务器故障
+	RepNotAllowed           = 0x02 // 规则不允许的连接
+	RepNetworkUnreachable   = 0x03 // 网络不可达
+	RepHostUnreachable      = 0x04 // 主机不可达
+	RepConnectionRefused    = 0x05 // 连接被拒绝
+	RepTTLExpired           = 0x06 // TTL 超时
+	RepCommandNotSupported  = 0x07 // 不支持的命令
+	RepAddrTypeNotSupported = 0x08 // 不支持的地址类型
 )
 
 // SOCKS5Server 实现了基于用户名密码动态转发的 SOCKS5 代理服务。
-// 支持标准 SOCKS5 协议，支持用户名密码认证，
+// 支持标准 SOCKS5 协议，支持用户名密码认证，同时兼容 SOCKS4/SOCKS4a CONNECT，
 // 可根据用户认证信息动态选择下游代理。
 type SOCKS5Server struct {
-	addr string
+	addr  string
+	opts  serverOptions
+	state serverState
 }
 
 // NewSOCKS5Server 创建一个新的 SOCKS5Server 实例。
-// 参数 addr 为监听地址（如 ":1080"），返回 SOCKS5Server 指针。
-func NewSOCKS5Server(addr string) *SOCKS5Server {
-	return &SOCKS5Server{addr: addr}
+// 参数 addr 为监听地址（如 ":1080"），opts 为可选参数（超时等），返回 SOCKS5Server 指针。
+func NewSOCKS5Server(addr string, opts ...Option) *SOCKS5Server {
+	return &SOCKS5Server{addr: addr, opts: newServerOptions(opts)}
 }
 
 // Start 启动 SOCKS5 代理服务器，监听指定地址并处理客户端请求。
@@ -40,213 +59,231 @@ func (s *SOCKS5Server) Start() error {
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
 	log.Printf("SOCKS5 代理已启动，监听地址: %s", s.addr)
-	for {
-		// 接受新连接，主循环
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("Accept error: %v", err)
-			continue
-		}
-		// 每个连接独立 goroutine 处理，防止阻塞主循环
-		go s.handleConnection(conn)
-	}
+	return s.Serve(context.Background(), listener)
+}
+
+// Serve 在 listener 上接受并处理客户端连接，直到 ctx 取消或调用 Shutdown。
+// 服务关闭后返回 ErrServerClosed；已建立的会话不受 ctx 取消影响，由 Shutdown 负责排空。
+func (s *SOCKS5Server) Serve(ctx context.Context, listener net.Listener) error {
+	return s.state.serve(ctx, s.opts.wrapProxyProto(listener), s.handleConnection)
+}
+
+// Shutdown 优雅关闭 SOCKS5 代理服务器：停止接收新连接，等待活跃会话结束；
+// ctx 到期时强制关闭剩余会话并返回 ctx.Err()。
+func (s *SOCKS5Server) Shutdown(ctx context.Context) error {
+	return s.state.drain(ctx)
+}
+
+// ActiveSessions 返回当前活跃会话数。
+func (s *SOCKS5Server) ActiveSessions() int {
+	return s.state.activeSessions()
 }
 
-// handleConnection 处理单个 SOCKS5 客户端连接。
-// 完成认证、目标地址解析、下游代理选择和数据转发。
+// handleConnection 处理单个 SOCKS 客户端连接。
+// 完成认证、目标地址解析、下游代理选择和数据转发；SOCKS4/SOCKS4a 请求交由 handleSOCKS4 处理。
 // 参数 conn 为客户端连接。
 func (s *SOCKS5Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	// 1. 处理 SOCKS5 握手和认证
-	username, password, err := s.handleHandshake(conn)
+	if !s.state.addSession(conn) {
+		return
+	}
+	defer s.state.removeSession(conn)
+	// 握手、认证与请求解析须在期限内完成，防止空连接长期占用 goroutine
+	if s.opts.handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.opts.handshakeTimeout))
+	}
+	// 0. 按版本号分发，SOCKS4/SOCKS4a 走独立流程
+	bc := newBufferedConn(conn)
+	if ver, err := bc.Peek(1); err == nil && ver[0] == SOCKS4Version {
+		s.handleSOCKS4(bc)
+		return
+	}
+	conn = bc
+	// 1. 处理 SOCKS5 握手和认证，无认证方法仅在匿名策略允许时被选中
+	username, password, proxyAddr, err := s.handleHandshake(conn)
 	if err != nil {
 		log.Printf("Handshake error: %v", err)
 		return
 	}
-	// 2. 根据认证信息查找下游代理
-	proxyAddr := s.authenticate(username, password)
-	if proxyAddr == "" {
-		// 认证失败，返回认证失败响应
-		conn.Write([]byte{0x01, 0x01})
-		log.Printf("Authentication failed for user: %s", username)
+	var params UserParams
+	if proxyAddr != "" {
+		log.Printf("Anonymous client %s, using proxy: %s", conn.RemoteAddr(), proxyAddr)
+	} else {
+		// 2. 根据认证信息查找下游代理，用户名可携带会话与节点筛选参数
+		proxyAddr, params = s.authenticate(username, password)
+		if proxyAddr == "" {
+			// 认证失败，返回认证失败响应
+			conn.Write([]byte{0x01, 0x01})
+			log.Printf("Authentication failed for user: %s", username)
+			return
+		}
+		// 3. 认证成功，发送认证成功响应
+		if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+			log.Printf("Failed to send auth success response: %v", err)
+			return
+		}
+		log.Printf("User %s authenticated, using proxy: %s", username, proxyAddr)
+	}
+	// 4. 解析客户端请求的命令与目标地址
+	cmd, targetAddr, err := s.readRequest(conn)
+	if err != nil {
+		log.Printf("Request handling error: %v", err)
+		if errors.Is(err, ErrAddrTypeNotSupported) {
+			writeSOCKS5Reply(conn, RepAddrTypeNotSupported, "")
+		}
+		return
+	}
+	switch cmd {
+	case ConnectCmd, BindCmd:
+	case UDPAssociateCmd:
+		// UDP ASSOCIATE 由独立的中继逻辑处理，规则按每个数据报的目标地址匹配，控制连接关闭时会话结束
+		s.handleUDPAssociate(conn, proxyAddr, params, targetAddr)
+		return
+	default:
+		// 不支持的命令
+		writeSOCKS5Reply(conn, RepCommandNotSupported, "")
+		log.Printf("Unsupported SOCKS5 command: %d", cmd)
 		return
 	}
-	// 3. 认证成功，发送认证成功响应
-	if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
-		log.Printf("Failed to send auth success response: %v", err)
+	// 5. 按路由规则为目标地址（BIND 时为预期的对端地址）选择路由
+	ctx := contextWithProxyHeader(context.Background(), s.opts.proxyHeaderOut, conn.RemoteAddr(), conn.LocalAddr())
+	if proxyAddr, err = applyRules(ctx, params.Key, proxyAddr, targetAddr); err != nil {
+		log.Printf("Connection to %s refused: %v", targetAddr, err)
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
 		return
 	}
-	log.Printf("User %s authenticated, using proxy: %s", username, proxyAddr)
-	// 4. 解析客户端请求的目标地址
-	targetAddr, err := s.handleConnect(conn)
-	if err != nil {
-		log.Printf("Connect handling error: %v", err)
+	if cmd == BindCmd {
+		// BIND 需要两次应答，单独处理
+		s.handleBind(ctx, conn, proxyAddr, targetAddr)
 		return
 	}
-	// 5. 通过下游代理建立到目标地址的连接
-	connector, err := getProxyConnector(proxyAddr)
+	// 通过下游代理建立到目标地址的连接
+	dialer, err := DialerFor(proxyAddr)
 	if err != nil {
-		log.Printf("getProxyConnector error: %v", err)
-		// 返回 SOCKS5 连接失败响应
-		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		log.Printf("DialerFor error: %v", err)
+		// 路由配置错误属于服务端故障
+		writeSOCKS5Reply(conn, RepGeneralFailure, "")
 		return
 	}
-	proxyConn, err := connector(targetAddr)
+	proxyConn, err := dialer.DialContext(withUserParams(ctx, params), "tcp", targetAddr)
 	if err != nil {
 		log.Printf("Failed to connect to proxy %s: %v", proxyAddr, err)
-		// 返回 SOCKS5 连接失败响应
-		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		// 按失败原因返回对应的 SOCKS5 应答码
+		writeSOCKS5Reply(conn, socks5ReplyCode(err), "")
 		return
 	}
 	defer proxyConn.Close()
-	// 6. 通知客户端连接建立成功
-	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
-	// 7. 开始双向转发数据
+	// 6. 通知客户端连接建立成功，BND 为上游连接的本地地址
+	if err := writeSOCKS5Reply(conn, RepSucceeded, proxyConn.LocalAddr().String()); err != nil {
+		return
+	}
+	// 7. 清除握手期限，开始双向转发数据
+	conn.SetDeadline(time.Time{})
 	s.relay(conn, proxyConn)
 }
 
 // handleHandshake 处理 SOCKS5 握手和认证流程。
-// 返回客户端提供的用户名、密码和错误信息。
+// 客户端支持用户名密码认证时优先使用；仅支持无认证方法时按匿名策略选择路由，策略拒绝则返回 0xFF。
 // 参数 conn 为客户端连接。
-// 返回值：用户名、密码、error。
-func (s *SOCKS5Server) handleHandshake(conn net.Conn) (string, string, error) {
+// 返回值：用户名、密码、匿名路由（选择无认证方法时非空）、error。
+func (s *SOCKS5Server) handleHandshake(conn net.Conn) (string, string, string, error) {
 	// 读取客户端发来的 VER、NMETHODS
 	buf := make([]byte, 2)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	version, nMethods := buf[0], buf[1]
 	if version != SOCKS5Version {
-		return "", "", io.ErrUnexpectedEOF
+		return "", "", "", io.ErrUnexpectedEOF
 	}
 	// 读取支持的认证方法
 	methods := make([]byte, nMethods)
 	if _, err := io.ReadFull(conn, methods); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
-	// 检查是否支持用户名密码认证
-	supportUserPass := false
+	// 检查是否支持用户名密码认证或无认证
+	supportUserPass, supportNoAuth := false, false
 	for _, m := range methods {
-		if m == UserPassAuth {
+		switch m {
+		case UserPassAuth:
 			supportUserPass = true
-			break
+		case NoAuth:
+			supportNoAuth = true
 		}
-
 	}
 	if !supportUserPass {
+		if supportNoAuth {
+			if route := s.opts.anonymous.Route(conn.RemoteAddr().String()); route != "" {
+				_, err := conn.Write([]byte{SOCKS5Version, NoAuth})
+				return "", "", route, err
+			}
+		}
 		// 不支持则返回 0xFF，协议要求
 		conn.Write([]byte{SOCKS5Version, 0xFF})
-		return "", "", fmt.Errorf("client does not support username/password auth")
+		return "", "", "", fmt.Errorf("client does not support username/password auth")
 	}
 	// 通知客户端选择用户名密码认证
 	if _, err := conn.Write([]byte{SOCKS5Version, UserPassAuth}); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	// 读取认证子协商：VER、ULEN、UNAME、PLEN、PASSWD
 	buf = make([]byte, 2)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	if buf[0] != 0x01 {
-		return "", "", io.ErrUnexpectedEOF
+		return "", "", "", io.ErrUnexpectedEOF
 	}
 	usernameLen := buf[1]
 	username := make([]byte, usernameLen)
 	if _, err := io.ReadFull(conn, username); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	buf = make([]byte, 1)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	passwordLen := buf[0]
 	password := make([]byte, passwordLen)
 	if _, err := io.ReadFull(conn, password); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
-	return string(username), string(password), nil
+	return string(username), string(password), "", nil
 }
 
 // authenticate 根据用户名密码查找下游代理地址。
+// 用户名可在注册 key 之后携带参数（如 key-session-abc123-country-us），见 ParseUsername。
 // 参数 username、password 为客户端认证信息。
-// 返回值：匹配的下游代理地址字符串。
-func (s *SOCKS5Server) authenticate(username, password string) string {
-	// 拼接 key 查询用户映射表
-	userKey := username + ":" + password
-	userProxyMapLock.RLock()
-	defer userProxyMapLock.RUnlock()
-	return UserProxyMap[userKey]
+// 返回值：匹配的下游代理地址字符串、用户名参数。
+func (s *SOCKS5Server) authenticate(username, password string) (string, UserParams) {
+	return lookupUser(username, password)
 }
 
-// handleConnect 解析 SOCKS5 CONNECT 请求，获取目标地址。
+// readRequest 解析 SOCKS5 请求（CONNECT、UDP ASSOCIATE 等），获取命令和目标地址。
 // 参数 conn 为客户端连接。
-// 返回值：目标地址字符串（host:port）、error。
-func (s *SOCKS5Server) handleConnect(conn net.Conn) (string, error) {
-	// 读取 SOCKS5 CONNECT 请求头部
+// 返回值：命令字、目标地址字符串（host:port）、error。
+func (s *SOCKS5Server) readRequest(conn net.Conn) (byte, string, error) {
+	// 读取 SOCKS5 请求头部
 	buf := make([]byte, 4)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", err
+		return 0, "", err
 	}
 	version, cmd, _, addrType := buf[0], buf[1], buf[2], buf[3]
-	if version != SOCKS5Version || cmd != ConnectCmd {
-		return "", io.ErrUnexpectedEOF
+	if version != SOCKS5Version {
+		return 0, "", io.ErrUnexpectedEOF
 	}
 	// 解析目标地址
-	var addr string
-	switch addrType {
-	case IPv4Addr:
-		buf = make([]byte, 4)
-		if _, err := io.ReadFull(conn, buf); err != nil {
-			return "", err
-		}
-		addr = net.IP(buf).String()
-	case DomainAddr:
-		buf = make([]byte, 1)
-		if _, err := io.ReadFull(conn, buf); err != nil {
-			return "", err
-		}
-		domainLen := buf[0]
-		domain := make([]byte, domainLen)
-		if _, err := io.ReadFull(conn, domain); err != nil {
-			return "", err
-		}
-		addr = string(domain)
-	case IPv6Addr:
-		buf = make([]byte, 16)
-		if _, err := io.ReadFull(conn, buf); err != nil {
-			return "", err
-		}
-		addr = net.IP(buf).String()
-	default:
-		return "", io.ErrUnexpectedEOF
-	}
-	// 读取端口
-	buf = make([]byte, 2)
-	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", err
+	addr, err := readSOCKS5Addr(conn, addrType)
+	if err != nil {
+		return 0, "", err
 	}
-	port := int(buf[0])<<8 + int(buf[1])
-	return addr + ":" + fmt.Sprintf("%d", port), nil
+	return cmd, addr, nil
 }
 
 // relay 实现两个连接之间的双向数据转发。
-// 用于 CONNECT 隧道和 SOCKS5 隧道的数据转发。
+// 用于 CONNECT 隧道和 SOCKS5 隧道的数据转发，双向空闲超过 idleTimeout 时关闭隧道。
 // 参数 conn1、conn2 为需要互相转发数据的两个连接。
 func (s *SOCKS5Server) relay(conn1, conn2 net.Conn) {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	// 启动两个 goroutine 实现双向转发
-	go func() {
-		defer wg.Done()
-		io.Copy(conn1, conn2) // 下游 -> 客户端
-		conn1.Close()
-	}()
-	go func() {
-		defer wg.Done()
-		io.Copy(conn2, conn1) // 客户端 -> 下游
-		conn2.Close()
-	}()
-	wg.Wait()
+	relayConns(conn1, conn2, s.opts.idleTimeout)
 }
@@ -0,0 +1,371 @@
+package gost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKeepAliveInterval 为持久 SSH 连接发送保活请求的间隔，保活失败即关闭连接，下次建连时重建。
+const sshKeepAliveInterval = 30 * time.Second
+
+// sshIdleTimeout 为持久 SSH 连接上没有打开的通道时保留连接的时长。
+const sshIdleTimeout = 5 * time.Minute
+
+// SSHDialer 经 SSH 服务器的 direct-tcpip 通道建立连接（相当于 ssh -W）。
+// 经同一 SSHDialer 的连接复用一条持久的 SSH 连接，在其上多路复用通道；
+// SSH 连接断开或空闲超过 5 分钟后关闭，下次建连时重建。
+// Via 非 nil 时经 Via（如代理链中的前序各层）连接 SSH 服务器，否则直接连接；持久连接由各用户共用，不发送 PROXY protocol 头部。
+type SSHDialer struct {
+	Addr    string            // 服务器地址 host:port
+	Config  *ssh.ClientConfig // 用户名、认证方式与主机密钥校验
+	Via     Dialer
+	Timeout time.Duration // 与服务器完成 SSH 握手的超时（直接连接时包括 TCP 建连），0 表示不单独限制
+
+	mu     sync.Mutex
+	client *sshClient
+}
+
+// DialContext 经持久 SSH 连接打开到 addr 的通道，域名按解析配置（见 SetDNS）解析。
+// 持久连接已失效（如服务器重启）时重建连接并重试一次。
+func (d *SSHDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if err := checkStreamNetwork(network); err != nil {
+		return nil, err
+	}
+	addr, err := resolveAddr(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c, fresh, err := d.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.open(ctx, addr)
+	var openErr *ssh.OpenChannelError
+	if err != nil && !fresh && ctx.Err() == nil && !errors.As(err, &openErr) {
+		d.drop(c)
+		if c, _, err = d.getClient(ctx); err != nil {
+			return nil, err
+		}
+		conn, err = c.open(ctx, addr)
+	}
+	if err != nil {
+		return nil, d.channelError(addr, err, ctx.Err() != nil)
+	}
+	return conn, nil
+}
+
+// HopAddr 返回服务器地址。
+func (d *SSHDialer) HopAddr() string { return d.Addr }
+
+func (d *SSHDialer) hopTimeout() time.Duration { return d.Timeout }
+
+// Tunnel 在 conn 上完成 SSH 握手并打开到 addr 的通道，通道关闭时一并关闭该 SSH 连接。
+// 该方式不复用连接；经 Route 创建的代理链中 ssh 节点使用持久连接（见 chainDialer）。
+func (d *SSHDialer) Tunnel(conn net.Conn, addr string) (net.Conn, error) {
+	client, err := d.handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := client.Dial("tcp", addr)
+	if err != nil {
+		client.Close()
+		return nil, d.channelError(addr, err, false)
+	}
+	return &sshChannelConn{Conn: ch, release: func() { client.Close() }}, nil
+}
+
+// getClient 返回可用的持久连接，没有时建立新连接，fresh 表示连接为本次新建。
+// 建立连接期间持有锁，并发建连等待同一条连接建立完成。
+func (d *SSHDialer) getClient(ctx context.Context) (c *sshClient, fresh bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil && !d.client.closed() {
+		return d.client, false, nil
+	}
+	if c, err = d.connect(ctx); err != nil {
+		return nil, false, err
+	}
+	d.client = c
+	return c, true, nil
+}
+
+// drop 关闭已失效的持久连接，下次建连时重建。
+func (d *SSHDialer) drop(c *sshClient) {
+	d.mu.Lock()
+	if d.client == c {
+		d.client = nil
+	}
+	d.mu.Unlock()
+	c.Close()
+}
+
+// connect 连接 SSH 服务器（Via 非 nil 时经 Via）并完成握手。
+func (d *SSHDialer) connect(ctx context.Context) (*sshClient, error) {
+	// 持久连接由各用户共用，不携带发起建连的用户参数与 PROXY protocol 头部
+	ctx = context.WithValue(ctx, userParamsKey{}, UserParams{})
+	ctx = context.WithValue(ctx, proxyHeaderKey{}, []byte(nil))
+	hctx, cancel := hopContext(ctx, d)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if d.Via != nil {
+		conn, err = d.Via.DialContext(hctx, "tcp", d.Addr)
+		if err != nil && !errors.Is(err, ErrNodeUnreachable) {
+			err = newHopError(d.Addr, err)
+		}
+	} else {
+		conn, err = dialHop(hctx, d.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var client *ssh.Client
+	err = handshakeContext(hctx, conn, func() error {
+		var err error
+		client, err = d.handshake(conn)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("SSH: connected to %s as %s", d.Addr, d.Config.User)
+	return newSSHClient(client, d.Addr), nil
+}
+
+// handshake 在 conn 上完成 SSH 握手与认证。
+func (d *SSHDialer) handshake(conn net.Conn) (*ssh.Client, error) {
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.Addr, d.Config)
+	if err != nil {
+		return nil, newHopError(d.Addr, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// channelError 归类打开通道失败的原因：服务器拒绝时按目标地址分类，ctx 结束时按超时或取消处理，
+// 其余错误（SSH 连接中断）按节点不可达处理。
+func (d *SSHDialer) channelError(addr string, err error, ctxDone bool) error {
+	var openErr *ssh.OpenChannelError
+	switch {
+	case errors.As(err, &openErr):
+		return &DialError{Addr: addr, Kind: openChannelKind(openErr), Err: err}
+	case ctxDone:
+		return newTargetError(addr, err)
+	default:
+		return newHopError(d.Addr, err)
+	}
+}
+
+// openChannelKind 将服务器拒绝打开通道的原因归类为分类错误。
+func openChannelKind(err *ssh.OpenChannelError) error {
+	switch {
+	case err.Reason == ssh.Prohibited:
+		return ErrNotAllowed
+	case err.Reason == ssh.ConnectionFailed && strings.Contains(strings.ToLower(err.Message), "refused"):
+		return ErrConnectionRefused
+	case err.Reason == ssh.ConnectionFailed:
+		return ErrHostUnreachable
+	default:
+		return ErrGeneralFailure
+	}
+}
+
+// ========== 持久连接 ===========
+// sshClient 为持久的 SSH 连接，记录打开的通道数，没有通道时开始计算空闲时间。
+type sshClient struct {
+	*ssh.Client
+	done chan struct{} // 连接关闭后关闭
+
+	mu     sync.Mutex
+	active int
+	idle   *time.Timer
+}
+
+func newSSHClient(client *ssh.Client, addr string) *sshClient {
+	c := &sshClient{Client: client, done: make(chan struct{})}
+	c.idle = time.AfterFunc(sshIdleTimeout, c.closeIfIdle)
+	go func() {
+		err := client.Wait()
+		close(c.done)
+		c.idle.Stop()
+		log.Printf("SSH: connection to %s closed: %v", addr, err)
+	}()
+	go c.keepAlive()
+	return c
+}
+
+func (c *sshClient) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// open 打开到 addr 的 direct-tcpip 通道。
+func (c *sshClient) open(ctx context.Context, addr string) (net.Conn, error) {
+	c.mu.Lock()
+	c.active++
+	c.idle.Stop()
+	c.mu.Unlock()
+	conn, err := c.Client.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	return &sshChannelConn{Conn: conn, release: sync.OnceFunc(c.release)}, nil
+}
+
+// release 在通道关闭后调用，最后一个通道关闭时开始计算空闲时间。
+func (c *sshClient) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active--; c.active == 0 {
+		c.idle.Reset(sshIdleTimeout)
+	}
+}
+
+func (c *sshClient) closeIfIdle() {
+	c.mu.Lock()
+	idle := c.active == 0
+	c.mu.Unlock()
+	if idle {
+		c.Close()
+	}
+}
+
+// keepAlive 定期发送 keepalive@openssh.com 请求，失败时关闭连接。
+func (c *sshClient) keepAlive() {
+	t := time.NewTicker(sshKeepAliveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// sshChannelConn 为 SSH 通道上的连接，关闭时通知所属的 SSH 连接。
+// 通道不支持读写期限，握手超时时由 handshakeContext 直接关闭连接。
+type sshChannelConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *sshChannelConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// ========== 节点配置 ===========
+// clientConfig 生成连接 ssh 节点的客户端配置，私钥、密码与 known_hosts 文件在此时读取。
+// password 为节点配置中直接填写的密码，与 password_file 只能设置其一。
+func (o *HopSSHOptions) clientConfig(user, password string) (*ssh.ClientConfig, error) {
+	if user == "" {
+		return nil, errors.New("ssh 节点需要用户名")
+	}
+	if o == nil {
+		o = &HopSSHOptions{}
+	}
+	cfg := &ssh.ClientConfig{User: user}
+	if o.KeyFile != "" {
+		pem, err := os.ReadFile(o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 SSH 私钥文件失败: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("SSH 私钥无效（不支持设置了口令的私钥）: %w", err)
+		}
+		cfg.Auth = append(cfg.Auth, ssh.PublicKeys(signer))
+	}
+	if o.PasswordFile != "" {
+		if password != "" {
+			return nil, errors.New("ssh 节点的 password 与 password_file 只能设置其一")
+		}
+		b, err := os.ReadFile(o.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 SSH 密码文件失败: %w", err)
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	}
+	if password != "" {
+		cfg.Auth = append(cfg.Auth, ssh.Password(password))
+	}
+	if len(cfg.Auth) == 0 {
+		return nil, errors.New("ssh 节点需要 key_file、password_file 或 password")
+	}
+	switch {
+	case o.KnownHostsFile != "" && o.InsecureIgnoreHostKey:
+		return nil, errors.New("ssh 节点的 known_hosts_file 与 insecure_ignore_host_key 只能设置其一")
+	case o.KnownHostsFile != "":
+		cb, err := knownhosts.New(o.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 known_hosts 文件失败: %w", err)
+		}
+		cfg.HostKeyCallback = cb
+	case o.InsecureIgnoreHostKey:
+		cfg.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("ssh 节点需要 known_hosts_file 校验主机密钥，或显式设置 insecure_ignore_host_key")
+	}
+	return cfg, nil
+}
+
+// parseLegacySSH 解析旧式 ssh 地址的查询参数 key、password_file、known_hosts、insecure，端口缺省为 22。
+func parseLegacySSH(u *url.URL, hop *Hop) error {
+	if u.Port() == "" {
+		hop.Addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	q := u.Query()
+	hop.SSH = &HopSSHOptions{KeyFile: q.Get("key"), PasswordFile: q.Get("password_file"), KnownHostsFile: q.Get("known_hosts")}
+	switch q.Get("insecure") {
+	case "", "0", "false":
+	case "1", "true":
+		hop.SSH.InsecureIgnoreHostKey = true
+	default:
+		return fmt.Errorf("下游代理 insecure 参数无效: %s", q.Get("insecure"))
+	}
+	return nil
+}
+
+// chainDialer 组装代理链。ssh 节点使用持久连接：最后一个 ssh 节点经其前序各层连接服务器，
+// 其后各层经该节点打开的通道建立；没有 ssh 节点时即为普通的 ChainDialer。
+func chainDialer(hops []HopDialer) Dialer {
+	for i := len(hops) - 1; i >= 0; i-- {
+		s, ok := hops[i].(*SSHDialer)
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			s.Via = chainDialer(hops[:i])
+		}
+		if i == len(hops)-1 {
+			return s
+		}
+		return &ChainDialer{Base: s, Hops: hops[i+1:]}
+	}
+	if len(hops) == 1 {
+		return hops[0]
+	}
+	return &ChainDialer{Hops: hops}
+}
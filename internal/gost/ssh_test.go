@@ -0,0 +1,291 @@
+package gost
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer 为进程内的 SSH 服务器，只处理 direct-tcpip 通道
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+
+	mu    sync.Mutex
+	conns []net.Conn // 已完成握手的 SSH 连接
+}
+
+// count 返回已完成握手的 SSH 连接数
+func (s *testSSHServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// closeAll 关闭所有 SSH 连接，模拟服务器重启
+func (s *testSSHServer) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+// startTestSSHServer 启动 SSH 服务器，接受以 authorized 私钥或 password 认证的用户 ops
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey, password string) *testSSHServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("生成主机密钥失败: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("生成主机密钥失败: %v", err)
+	}
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "ops" && authorized != nil && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "ops" && password != "" && string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+	}
+	cfg.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &testSSHServer{addr: ln.Addr().String(), hostKey: signer.PublicKey()}
+	t.Cleanup(s.closeAll)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "direct-tcpip" {
+			newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		var req struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newCh.ExtraData(), &req); err != nil {
+			newCh.Reject(ssh.ConnectionFailed, "invalid request")
+			continue
+		}
+		target, err := net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+		if err != nil {
+			newCh.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			defer ch.Close()
+			defer target.Close()
+			go io.Copy(target, ch)
+			io.Copy(ch, target)
+		}()
+	}
+}
+
+// writeTestSSHKey 生成 ed25519 私钥并以 OpenSSH 格式写入临时文件，返回文件路径与公钥
+func writeTestSSHKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("写入私钥失败: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("生成公钥失败: %v", err)
+	}
+	return path, sshPub
+}
+
+// writeKnownHosts 写入只包含 addr 主机密钥的 known_hosts 文件
+func writeKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatalf("写入 known_hosts 失败: %v", err)
+	}
+	return path
+}
+
+// TestSSHDialer 测试 ssh 节点的认证、主机密钥校验与持久连接复用
+func TestSSHDialer(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	keyFile, pub := writeTestSSHKey(t)
+	server := startTestSSHServer(t, pub, "s3cret")
+	knownHosts := writeKnownHosts(t, server.addr, server.hostKey)
+
+	t.Run("私钥认证并复用连接", func(t *testing.T) {
+		r := Route{Hops: []Hop{{Type: HopSSH, Addr: server.addr, Username: "ops", SSH: &HopSSHOptions{KeyFile: keyFile, KnownHostsFile: knownHosts}}}}
+		d, err := r.Dialer()
+		if err != nil {
+			t.Fatalf("创建 Dialer 失败: %v", err)
+		}
+		before := server.count()
+		for i := 0; i < 3; i++ {
+			conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+			if err != nil {
+				t.Fatalf("第 %d 次建连失败: %v", i+1, err)
+			}
+			defer conn.Close()
+			echoRoundTrip(t, conn, []byte("hello over ssh"))
+		}
+		if n := server.count() - before; n != 1 {
+			t.Errorf("各连接应复用同一条 SSH 连接，实际建立 %d 条", n)
+		}
+
+		// 服务器断开后下次建连时重建 SSH 连接
+		server.closeAll()
+		conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("SSH 连接断开后建连失败: %v", err)
+		}
+		defer conn.Close()
+		echoRoundTrip(t, conn, []byte("reconnected"))
+		if n := server.count() - before; n != 2 {
+			t.Errorf("期望重建 1 条 SSH 连接，实际共 %d 条", n)
+		}
+	})
+
+	t.Run("密码文件认证", func(t *testing.T) {
+		passwordFile := filepath.Join(t.TempDir(), "password")
+		if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+			t.Fatalf("写入密码文件失败: %v", err)
+		}
+		d, err := ParseRoute("ssh://ops@" + server.addr + "?password_file=" + passwordFile + "&insecure=1")
+		if err != nil {
+			t.Fatalf("解析路由失败: %v", err)
+		}
+		conn, err := d.DialContext(context.Background(), "tcp", echoAddr)
+		if err != nil {
+			t.Fatalf("建连失败: %v", err)
+		}
+		defer conn.Close()
+		echoRoundTrip(t, conn, []byte("ping"))
+	})
+
+	t.Run("主机密钥不匹配", func(t *testing.T) {
+		_, other := writeTestSSHKey(t)
+		r := Route{Hops: []Hop{{Type: HopSSH, Addr: server.addr, Username: "ops", SSH: &HopSSHOptions{KeyFile: keyFile, KnownHostsFile: writeKnownHosts(t, server.addr, other)}}}}
+		d, err := r.Dialer()
+		if err != nil {
+			t.Fatalf("创建 Dialer 失败: %v", err)
+		}
+		if _, err := d.DialContext(context.Background(), "tcp", echoAddr); !errors.Is(err, ErrNodeUnreachable) {
+			t.Errorf("主机密钥不匹配时期望 ErrNodeUnreachable，实际 %v", err)
+		}
+	})
+
+	t.Run("目标拒绝连接", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to create listener: %v", err)
+		}
+		closedAddr := ln.Addr().String()
+		ln.Close()
+		r := Route{Hops: []Hop{{Type: HopSSH, Addr: server.addr, Username: "ops", SSH: &HopSSHOptions{KeyFile: keyFile, KnownHostsFile: knownHosts}}}}
+		d, err := r.Dialer()
+		if err != nil {
+			t.Fatalf("创建 Dialer 失败: %v", err)
+		}
+		if _, err := d.DialContext(context.Background(), "tcp", closedAddr); !errors.Is(err, ErrConnectionRefused) {
+			t.Errorf("期望 ErrConnectionRefused，实际 %v", err)
+		}
+	})
+	t.Logf("✅ ssh 节点认证与连接复用正常")
+}
+
+// TestSSHChain 测试 ssh 节点位于代理链中间与最后一层
+func TestSSHChain(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	keyFile, pub := writeTestSSHKey(t)
+	server := startTestSSHServer(t, pub, "")
+	socksAddr := startFakeSOCKS5WithBND(t, "127.0.0.1:0")
+	sshURL := "ssh://ops@" + server.addr + "?key=" + keyFile + "&known_hosts=" + writeKnownHosts(t, server.addr, server.hostKey)
+
+	for name, route := range map[string]string{
+		"socks5 -> ssh":           "socks5://" + socksAddr + " -> " + sshURL,
+		"ssh -> socks5":           sshURL + " -> socks5://" + socksAddr,
+		"socks5 -> ssh -> socks5": "socks5://" + socksAddr + " -> " + sshURL + " -> socks5://" + socksAddr,
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, err := ParseRoute(route)
+			if err != nil {
+				t.Fatalf("解析路由失败: %v", err)
+			}
+			before := server.count()
+			for i := 0; i < 2; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				conn, err := d.DialContext(ctx, "tcp", echoAddr)
+				cancel()
+				if err != nil {
+					t.Fatalf("建连失败: %v", err)
+				}
+				echoRoundTrip(t, conn, []byte("hello over ssh chain"))
+				conn.Close()
+			}
+			if n := server.count() - before; n != 1 {
+				t.Errorf("各连接应复用同一条 SSH 连接，实际建立 %d 条", n)
+			}
+		})
+	}
+}
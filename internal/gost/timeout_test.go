@@ -0,0 +1,84 @@
+package gost
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestSOCKS5HandshakeTimeout 测试客户端未在握手期限内完成握手时连接被关闭
+func TestSOCKS5HandshakeTimeout(t *testing.T) {
+	serverAddr := startSOCKS5ServerWithOptions(t, WithHandshakeTimeout(200*time.Millisecond))
+
+	tests := []struct {
+		name string
+		send []byte
+	}{
+		{"连接后不发送任何数据", nil},
+		{"只发送部分方法协商", []byte{0x05, 0x02}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", serverAddr)
+			if err != nil {
+				t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+			}
+			defer conn.Close()
+			if tt.send != nil {
+				conn.Write(tt.send)
+			}
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			start := time.Now()
+			_, err = io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("期望服务端在握手超时后关闭连接，实际: %v", err)
+			}
+			t.Logf("✅ 握手超时后连接已关闭，耗时 %v", time.Since(start))
+		})
+	}
+}
+
+// TestSOCKS5IdleTimeout 测试隧道双向空闲超过 idleTimeout 后被关闭，有数据时保持打开
+func TestSOCKS5IdleTimeout(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["idleuser:idlepass"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+	serverAddr := startSOCKS5ServerWithOptions(t, WithIdleTimeout(300*time.Millisecond))
+
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	socks5TestLogin(t, conn, "idleuser", "idlepass")
+	req := []byte{0x05, ConnectCmd, 0x00}
+	addr, _ := encodeSOCKS5Addr(echoAddr)
+	conn.Write(append(req, addr...))
+	if rep, _, err := readSOCKS5Reply(conn); err != nil || rep != RepSucceeded {
+		t.Fatalf("CONNECT 失败: rep=%d, err=%v", rep, err)
+	}
+
+	// 持续收发数据期间隧道不应被关闭
+	buf := make([]byte, 4)
+	for i := 0; i < 5; i++ {
+		time.Sleep(150 * time.Millisecond)
+		conn.Write([]byte("ping"))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("活跃隧道被意外关闭: %v", err)
+		}
+	}
+
+	// 停止收发后隧道应在空闲超时后关闭
+	start := time.Now()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("期望空闲隧道被关闭，实际: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("隧道关闭过早: %v", elapsed)
+	}
+	t.Logf("✅ 空闲隧道已在 %v 后关闭", time.Since(start))
+}
@@ -0,0 +1,143 @@
+package gost
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval 为检查证书文件是否更新的最小间隔。
+const certReloadInterval = 10 * time.Second
+
+// NewServerTLSConfig 根据证书与私钥文件创建代理监听使用的 TLS 配置。
+// 证书文件更新后（按修改时间判断）在后续握手中自动重新加载，加载失败时继续使用旧证书。
+// clientCAFile 非空时要求客户端出示由该 CA 签发的证书。
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile, interval: certReloadInterval}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+		// 代理请求需要劫持连接（CONNECT），只协商 HTTP/1.1
+		NextProtos: []string{"http/1.1"},
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端 CA 文件失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("客户端 CA 文件中没有有效证书: %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// certReloader 缓存服务端证书，并在证书或私钥文件修改后重新加载。
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // 已加载文件中较新的修改时间
+	checked time.Time // 上次检查文件的时间
+}
+
+// load 从文件加载证书与私钥。
+func (r *certReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载 TLS 证书失败: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// latestModTime 返回证书与私钥文件中较新的修改时间。
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// getCertificate 供 tls.Config.GetCertificate 使用，按间隔检查文件并在变化时重新加载。
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	cert := r.cert
+	due := time.Since(r.checked) >= r.interval
+	if due {
+		r.checked = time.Now()
+	}
+	loaded := r.modTime
+	r.mu.Unlock()
+	if !due {
+		return cert, nil
+	}
+	if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(loaded) {
+		if err := r.load(); err != nil {
+			log.Printf("TLS 证书重新加载失败，继续使用旧证书: %v", err)
+			return cert, nil
+		}
+		log.Printf("TLS 证书已重新加载: %s", r.certFile)
+		r.mu.Lock()
+		cert = r.cert
+		r.mu.Unlock()
+	}
+	return cert, nil
+}
+
+// clientConfig 生成连接 https 节点的 TLS 配置，host 为代理主机名，h 为 nil 时使用默认参数。
+func (h *HopTLS) clientConfig(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		// CONNECT 隧道只能经 HTTP/1.1 建立
+		NextProtos: []string{"http/1.1"},
+	}
+	if h == nil {
+		return cfg, nil
+	}
+	if h.ServerName != "" {
+		cfg.ServerName = h.ServerName
+	}
+	if h.CAFile != "" {
+		pem, err := os.ReadFile(h.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取下游代理 CA 文件失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("下游代理 CA 文件中没有有效证书: %s", h.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	cfg.InsecureSkipVerify = h.InsecureSkipVerify
+	return cfg, nil
+}
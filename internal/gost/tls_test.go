@@ -0,0 +1,276 @@
+package gost
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTestCert 生成证书并以 PEM 写入 dir，parent 为 nil 时自签名，返回证书与私钥
+func writeTestCert(t *testing.T, dir, name, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{cn},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// startTLSProxy 以 TLS 模式启动 HTTP 代理，返回监听地址
+func startTLSProxy(t *testing.T, tlsConfig *tls.Config) string {
+	t.Helper()
+	server := NewHTTPProxyServer(":0", WithTLSConfig(tlsConfig))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(context.Background(), listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return listener.Addr().String()
+}
+
+// TestHTTPSProxyConnect 测试 TLS 代理监听上的 CONNECT 认证与转发
+func TestHTTPSProxyConnect(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "server", "proxy", nil, nil)
+	tlsConfig, err := NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "")
+	if err != nil {
+		t.Fatalf("创建 TLS 配置失败: %v", err)
+	}
+	echoAddr := startEchoServer(t)
+	downstream := startFakeBindDownstream(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["tlsuser:tlsuser"] = "socks5://" + downstream
+	userProxyMapLock.Unlock()
+	proxyAddr := startTLSProxy(t, tlsConfig)
+
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"认证成功", "tlsuser:tlsuser", http.StatusOK},
+		{"认证失败", "tlsuser:wrong", http.StatusProxyAuthRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", proxyAddr, &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatalf("TLS 握手失败: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			req := "CONNECT " + echoAddr + " HTTP/1.1\r\nHost: " + echoAddr + "\r\n" +
+				"Proxy-Authorization: Basic " + base64EncodeString(tt.auth) + "\r\n\r\n"
+			conn.Write([]byte(req))
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("读取 CONNECT 响应失败: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("期望状态码 %d，实际 %d", tt.want, resp.StatusCode)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			if _, err := br.Read(buf); err != nil || string(buf) != "ping" {
+				t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+			}
+			t.Logf("✅ TLS 隧道转发正常")
+		})
+	}
+}
+
+// TestHTTPSProxyClientCert 测试开启客户端证书校验后未出示证书的客户端被拒绝
+func TestHTTPSProxyClientCert(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "server", "proxy", nil, nil)
+	caCert, caKey := writeTestCert(t, dir, "ca", "client-ca", nil, nil)
+	writeTestCert(t, dir, "client", "client", caCert, caKey)
+	tlsConfig, err := NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatalf("创建 TLS 配置失败: %v", err)
+	}
+	proxyAddr := startTLSProxy(t, tlsConfig)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatalf("加载客户端证书失败: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		wantErr bool
+	}{
+		{"出示有效客户端证书", []tls.Certificate{clientCert}, false},
+		{"未出示客户端证书", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", proxyAddr, &tls.Config{InsecureSkipVerify: true, Certificates: tt.certs})
+			if err == nil {
+				// TLS 1.3 下服务端的拒绝在首次读取时才返回
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				conn.Write([]byte("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+				_, err = http.ReadResponse(bufio.NewReader(conn), nil)
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("期望出错=%v，实际 err=%v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestCertReload 测试证书文件更新后自动重新加载
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := writeTestCert(t, dir, "server", "first", nil, nil)
+	reloader := &certReloader{certFile: filepath.Join(dir, "server.crt"), keyFile: filepath.Join(dir, "server.key")}
+	if err := reloader.load(); err != nil {
+		t.Fatalf("加载证书失败: %v", err)
+	}
+	cert, _ := reloader.getCertificate(nil)
+	if string(cert.Certificate[0]) != string(first.Raw) {
+		t.Fatalf("初始证书不正确")
+	}
+
+	// 覆盖证书文件，并把修改时间推后以避免文件系统时间精度问题
+	second, _ := writeTestCert(t, dir, "server", "second", nil, nil)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), future, future)
+	cert, _ = reloader.getCertificate(nil)
+	if string(cert.Certificate[0]) != string(second.Raw) {
+		t.Fatalf("证书更新后未重新加载")
+	}
+
+	// 写入无效证书时继续使用旧证书
+	os.WriteFile(filepath.Join(dir, "server.crt"), []byte("invalid"), 0600)
+	later := future.Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), later, later)
+	cert, _ = reloader.getCertificate(nil)
+	if cert == nil || string(cert.Certificate[0]) != string(second.Raw) {
+		t.Fatalf("无效证书不应替换旧证书")
+	}
+	t.Logf("✅ 证书热加载正常")
+}
+
+// TestHTTPSHop 测试 https 下游代理经 TLS 连接，支持 SNI、CA 与跳过校验参数，并可位于代理链中间
+func TestHTTPSHop(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := writeTestCert(t, dir, "ca", "hop-ca", nil, nil)
+	writeTestCert(t, dir, "server", "proxy.test", caCert, caKey)
+	tlsConfig, err := NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "")
+	if err != nil {
+		t.Fatalf("创建 TLS 配置失败: %v", err)
+	}
+	// 记录客户端发送的 SNI
+	var lastSNI atomic.Value
+	getCertificate := tlsConfig.GetCertificate
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		lastSNI.Store(hello.ServerName)
+		return getCertificate(hello)
+	}
+	userProxyMapLock.Lock()
+	UserProxyMap["hopsuser:hopspass"] = "direct"
+	userProxyMapLock.Unlock()
+	echoAddr := startEchoServer(t)
+	httpsAddr := startTLSProxy(t, tlsConfig)
+	plainAddr := startHTTPProxyServerWithOptions(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	httpsHop := "https://hopsuser:hopspass@" + httpsAddr
+	plainHop := "http://hopsuser:hopspass@" + plainAddr
+
+	tests := []struct {
+		name    string
+		route   string
+		wantSNI string
+		wantErr bool
+	}{
+		{"按 CA 校验证书", httpsHop + "?ca=" + caFile, "", false},
+		{"自定义 SNI", httpsHop + "?ca=" + caFile + "&sni=proxy.test", "proxy.test", false},
+		{"SNI 与证书不符", httpsHop + "?ca=" + caFile + "&sni=other.test", "", true},
+		{"未配置 CA", httpsHop, "", true},
+		{"跳过证书校验", httpsHop + "?insecure=1", "", false},
+		{"位于代理链中间", plainHop + " -> " + httpsHop + "?ca=" + caFile + "&sni=proxy.test -> " + plainHop, "proxy.test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lastSNI.Store("")
+			d, err := ParseRoute(tt.route)
+			if err != nil {
+				t.Fatalf("解析路由失败: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := d.DialContext(ctx, "tcp", echoAddr)
+			if tt.wantErr {
+				if err == nil {
+					conn.Close()
+					t.Fatal("期望 TLS 校验失败")
+				}
+				t.Logf("✅ 建连按预期失败: %v", err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("经 https 代理建连失败: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+			}
+			if got, _ := lastSNI.Load().(string); got != tt.wantSNI {
+				t.Errorf("期望 SNI %q，实际 %q", tt.wantSNI, got)
+			}
+		})
+	}
+
+	if _, err := ParseRoute(httpsHop + "?ca=" + filepath.Join(dir, "missing.crt")); err == nil {
+		t.Errorf("CA 文件不存在时应返回错误")
+	}
+}
@@ -0,0 +1,109 @@
+package gost
+
+import (
+	"context"
+	"strings"
+)
+
+// 用户名参数名：session 为会话 ID，其余为节点标签筛选条件
+const (
+	ParamSession = "session"
+	ParamCountry = "country"
+	ParamRegion  = "region"
+	ParamCity    = "city"
+	ParamTag     = "tag"
+)
+
+// usernameParams 为可出现在用户名中的参数名。
+var usernameParams = map[string]bool{
+	ParamSession: true,
+	ParamCountry: true,
+	ParamRegion:  true,
+	ParamCity:    true,
+	ParamTag:     true,
+}
+
+// UserParams 为用户名中注册 key 之后携带的结构化参数。
+type UserParams struct {
+	Key     string            // 注册 key，会话 ID 在各 key 内独立
+	Session string            // 会话 ID，同一会话在 StickyTTL 内固定经同一节点
+	Labels  map[string]string // 节点标签筛选条件，如 country=us、tag=gpu
+}
+
+// IsZero 判断是否未携带会话或筛选参数。
+func (p UserParams) IsZero() bool {
+	return p.Session == "" && len(p.Labels) == 0
+}
+
+// ParseUsername 解析形如 key-session-abc123-country-us 的用户名，返回注册 key 与参数。
+// 参数以 "-名称-取值" 成对出现在 key 之后，名称见 ParamSession 等常量；
+// key 本身可以包含 "-"，取第一个使其后全部为合法参数对的位置作为分界。不含参数时 key 即用户名。
+func ParseUsername(username string) (string, UserParams) {
+	parts := strings.Split(username, "-")
+	for i := 1; i < len(parts); i++ {
+		if params, ok := parseUsernameParams(parts[i:]); ok {
+			params.Key = strings.Join(parts[:i], "-")
+			return params.Key, params
+		}
+	}
+	return username, UserParams{}
+}
+
+// parseUsernameParams 将 name, value, name, value... 解析为 UserParams，存在未知参数名或空值时返回 false。
+func parseUsernameParams(parts []string) (UserParams, bool) {
+	var params UserParams
+	if len(parts)%2 != 0 {
+		return params, false
+	}
+	for i := 0; i < len(parts); i += 2 {
+		name, value := strings.ToLower(parts[i]), parts[i+1]
+		if !usernameParams[name] || value == "" {
+			return UserParams{}, false
+		}
+		if name == ParamSession {
+			params.Session = value
+			continue
+		}
+		if params.Labels == nil {
+			params.Labels = make(map[string]string)
+		}
+		params.Labels[name] = value
+	}
+	return params, true
+}
+
+// lookupUser 按用户名密码查找路由；用户名未注册时按 ParseUsername 拆出 key 后以 key:password 查找。
+// 返回值：路由（未找到时为空）、用户名参数（Key 为注册 key，按此查找用户的路由规则）。
+func lookupUser(username, password string) (string, UserParams) {
+	userProxyMapLock.RLock()
+	defer userProxyMapLock.RUnlock()
+	if route := UserProxyMap[username+":"+password]; route != "" {
+		return route, UserParams{Key: username}
+	}
+	key, params := ParseUsername(username)
+	if params.IsZero() {
+		return "", UserParams{}
+	}
+	route := UserProxyMap[key+":"+password]
+	if route == "" {
+		return "", UserParams{}
+	}
+	return route, params
+}
+
+// userParamsKey 为 ctx 中用户名参数的键。
+type userParamsKey struct{}
+
+// withUserParams 返回携带用户名参数的 ctx，由 PoolDialer 据此筛选节点与固定会话，解析器据 Key 选择用户的 hosts 覆盖。
+func withUserParams(ctx context.Context, params UserParams) context.Context {
+	if params.IsZero() && params.Key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, userParamsKey{}, params)
+}
+
+// userParamsFromContext 返回 ctx 中的用户名参数，未设置时为零值。
+func userParamsFromContext(ctx context.Context) UserParams {
+	params, _ := ctx.Value(userParamsKey{}).(UserParams)
+	return params
+}
@@ -0,0 +1,183 @@
+package gost
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestParseUsername 测试用户名参数解析
+func TestParseUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		wantKey  string
+		want     UserParams
+	}{
+		{"无参数", "mykey", "mykey", UserParams{}},
+		{"key 含连字符", "team-a-key", "team-a-key", UserParams{}},
+		{"会话", "mykey-session-abc123", "mykey", UserParams{Key: "mykey", Session: "abc123"}},
+		{"会话与国家", "mykey-session-abc123-country-us", "mykey", UserParams{Key: "mykey", Session: "abc123", Labels: map[string]string{"country": "us"}}},
+		{"key 含连字符且带参数", "team-a-key-tag-gpu", "team-a-key", UserParams{Key: "team-a-key", Labels: map[string]string{"tag": "gpu"}}},
+		{"参数名不区分大小写", "mykey-Country-de", "mykey", UserParams{Key: "mykey", Labels: map[string]string{"country": "de"}}},
+		{"未知参数名", "mykey-foo-bar", "mykey-foo-bar", UserParams{}},
+		{"缺少取值", "mykey-session", "mykey-session", UserParams{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, params := ParseUsername(tt.username)
+			if key != tt.wantKey || !reflect.DeepEqual(params, tt.want) {
+				t.Errorf("期望 %q %+v，实际 %q %+v", tt.wantKey, tt.want, key, params)
+			}
+		})
+	}
+}
+
+// TestPoolStickySession 测试同一会话 ID 固定经同一节点，标签筛选节点
+func TestPoolStickySession(t *testing.T) {
+	d, stubs := newStubPool("sticky", StrategyRoundRobin, 3)
+	d.Members[0].Labels = map[string]string{"country": "us"}
+	d.Members[1].Labels = map[string]string{"country": "de", "tag": "gpu,residential"}
+	d.Members[2].Labels = map[string]string{"country": "us", "tag": "gpu"}
+
+	dial := func(params UserParams) {
+		t.Helper()
+		conn, err := d.DialContext(withUserParams(context.Background(), params), "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("建连失败: %v", err)
+		}
+		conn.Close()
+	}
+	hits := func() []int32 { return []int32{stubs[0].hits, stubs[1].hits, stubs[2].hits} }
+
+	t.Run("会话固定", func(t *testing.T) {
+		before := hits()
+		for i := 0; i < 5; i++ {
+			dial(UserParams{Key: "stickykey", Session: "s1"})
+		}
+		after := hits()
+		used := 0
+		for i := range after {
+			if after[i] != before[i] {
+				used++
+			}
+		}
+		if used != 1 {
+			t.Errorf("同一会话应固定经同一节点，命中变化 %v -> %v", before, after)
+		}
+	})
+
+	t.Run("标签筛选", func(t *testing.T) {
+		before := hits()
+		dial(UserParams{Key: "stickykey", Labels: map[string]string{"country": "DE"}})
+		dial(UserParams{Key: "stickykey", Labels: map[string]string{"tag": "residential"}})
+		if after := hits(); after[1]-before[1] != 2 {
+			t.Errorf("country-de 与 tag-residential 应只选择成员 1，命中 %v -> %v", before, after)
+		}
+		for i := 0; i < 4; i++ {
+			dial(UserParams{Key: "stickykey", Labels: map[string]string{"country": "us", "tag": "gpu"}})
+		}
+		if after := hits(); after[2]-before[2] != 4 {
+			t.Errorf("country-us-tag-gpu 应只选择成员 2，命中 %v -> %v", before, after)
+		}
+		_, err := d.DialContext(withUserParams(context.Background(), UserParams{Labels: map[string]string{"country": "jp"}}), "tcp", "example.com:80")
+		if err == nil {
+			t.Errorf("没有匹配的节点时应返回错误")
+		}
+	})
+
+	t.Run("会话过期后重新选择", func(t *testing.T) {
+		old := StickyTTL
+		StickyTTL = 50 * time.Millisecond
+		defer func() { StickyTTL = old }()
+		dial(UserParams{Key: "stickykey", Session: "s2"})
+		first, _ := stickySessions.get("stickykey|s2")
+		time.Sleep(100 * time.Millisecond)
+		if _, ok := stickySessions.get("stickykey|s2"); ok {
+			t.Fatalf("会话应已过期")
+		}
+		dial(UserParams{Key: "stickykey", Session: "s2"})
+		if second, ok := stickySessions.get("stickykey|s2"); !ok || second == "" {
+			t.Errorf("过期后应重新固定节点，之前为 %s", first)
+		}
+	})
+
+	t.Run("固定节点故障时改经其他节点", func(t *testing.T) {
+		dial(UserParams{Key: "stickykey", Session: "s3"})
+		pinned, _ := stickySessions.get("stickykey|s3")
+		for i, m := range d.Members {
+			if m.Name == pinned {
+				stub := stubs[i]
+				stub.err = newHopError(m.Name, io.ErrUnexpectedEOF)
+				defer func() { stub.err = nil }()
+			}
+		}
+		dial(UserParams{Key: "stickykey", Session: "s3"})
+		if now, _ := stickySessions.get("stickykey|s3"); now == pinned {
+			t.Errorf("固定节点故障后应改为固定到其他节点")
+		}
+	})
+}
+
+// TestUsernameParamsAuth 测试 SOCKS5 与 HTTP 代理按用户名参数认证并筛选节点
+func TestUsernameParamsAuth(t *testing.T) {
+	echoAddr := startEchoServer(t)
+	userProxyMapLock.Lock()
+	UserProxyMap["paramkey:paramkey"] = `{"pool":[{"direct":true,"labels":{"country":"us"}}]}`
+	userProxyMapLock.Unlock()
+	socksAddr := startTestSOCKS5Server(t)
+
+	tests := []struct {
+		name     string
+		username string
+		want     byte
+	}{
+		{"无参数", "paramkey", RepSucceeded},
+		{"匹配的标签", "paramkey-session-abc-country-us", RepSucceeded},
+		{"无匹配节点", "paramkey-country-de", RepGeneralFailure},
+	}
+	for _, tt := range tests {
+		t.Run("SOCKS5/"+tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", socksAddr)
+			if err != nil {
+				t.Fatalf("无法连接到 SOCKS5 服务器: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			socks5TestLogin(t, conn, tt.username, "paramkey")
+			dst, _ := encodeSOCKS5Addr(echoAddr)
+			conn.Write(append([]byte{SOCKS5Version, ConnectCmd, 0x00}, dst...))
+			rep, _, err := readSOCKS5Reply(conn)
+			if err != nil || rep != tt.want {
+				t.Errorf("期望应答码 %d，实际 %d, err=%v", tt.want, rep, err)
+			}
+		})
+	}
+
+	httpAddr := startHTTPProxyServerWithOptions(t)
+	for _, tt := range tests {
+		t.Run("HTTP/"+tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", httpAddr)
+			if err != nil {
+				t.Fatalf("无法连接到 HTTP 代理: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			tunnel, err := httpConnect(conn, echoAddr, tt.username, "paramkey")
+			if (err == nil) != (tt.want == RepSucceeded) {
+				t.Fatalf("期望成功=%v，实际 err=%v", tt.want == RepSucceeded, err)
+			}
+			if err != nil {
+				return
+			}
+			tunnel.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(tunnel, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("期望回显 ping，实际 %q, err=%v", buf, err)
+			}
+		})
+	}
+}
@@ -2,13 +2,38 @@ package headscale
 
 import (
 	"database/sql"
+	"encoding/json"
 )
 
-// SaveKeyIP 保存 key 和 ip 的映射关系到数据库
-func SaveKeyIP(db *sql.DB, key, ip string) error {
+// SaveKeyIP 保存 key 和节点地址的映射关系到数据库，ip 为转发路由中使用的地址，ip6 为节点的 IPv6 地址（可为空）
+func SaveKeyIP(db *sql.DB, key, ip, ip6 string) error {
 	_, err := db.Exec(
-		"INSERT INTO register_key_ip_map (reg_key, ip_address) VALUES ($1, $2) ON CONFLICT (reg_key) DO UPDATE SET ip_address = EXCLUDED.ip_address",
-		key, ip,
+		"INSERT INTO register_key_ip_map (reg_key, ip_address, ip6_address) VALUES ($1, $2, NULLIF($3, '')) ON CONFLICT (reg_key) DO UPDATE SET ip_address = EXCLUDED.ip_address, ip6_address = EXCLUDED.ip6_address",
+		key, ip, ip6,
 	)
 	return err
 }
+
+// AddPoolMember 将节点 ip 加入节点池 pool（不存在时以默认策略创建），labels 为节点标签（可为空），
+// 节点已在池中时更新标签。返回该池的负载均衡策略
+func AddPoolMember(db *sql.DB, pool, ip string, labels map[string]string) (string, error) {
+	var labelsJSON []byte
+	if len(labels) > 0 {
+		var err error
+		if labelsJSON, err = json.Marshal(labels); err != nil {
+			return "", err
+		}
+	}
+	if _, err := db.Exec("INSERT INTO register_key_pool (reg_key) VALUES ($1) ON CONFLICT (reg_key) DO NOTHING", pool); err != nil {
+		return "", err
+	}
+	if _, err := db.Exec(
+		"INSERT INTO register_key_pool_member (reg_key, ip_address, labels) VALUES ($1, $2, $3) ON CONFLICT (reg_key, ip_address) DO UPDATE SET labels = EXCLUDED.labels",
+		pool, ip, labelsJSON,
+	); err != nil {
+		return "", err
+	}
+	var strategy string
+	err := db.QueryRow("SELECT strategy FROM register_key_pool WHERE reg_key = $1", pool).Scan(&strategy)
+	return strategy, err
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"net"
 	"os/exec"
 	"strings"
 )
@@ -14,8 +15,22 @@ type RegisterResult struct {
 	Error       string   `json:"error"`
 }
 
-// RegisterNodeByDockerExec 通过 docker exec 调用 headscale 注册节点，返回第一个 IPv4 地址
-func RegisterNodeByDockerExec(key string) (string, error) {
+// NodeAddrs 为 headscale 分配给节点的 tailnet 地址，任一地址族可能为空
+type NodeAddrs struct {
+	IPv4 string
+	IPv6 string
+}
+
+// Primary 返回转发路由中使用的节点地址：有 IPv4 地址时为 IPv4，否则为 IPv6
+func (a NodeAddrs) Primary() string {
+	if a.IPv4 != "" {
+		return a.IPv4
+	}
+	return a.IPv6
+}
+
+// RegisterNodeByDockerExec 通过 docker exec 调用 headscale 注册节点，返回分配的第一个 IPv4 与第一个 IPv6 地址
+func RegisterNodeByDockerExec(key string) (NodeAddrs, error) {
 	cmd := exec.Command(
 		"docker", "exec", "-i", "headscale",
 		"headscale", "--user", "flink", "nodes", "register",
@@ -26,21 +41,39 @@ func RegisterNodeByDockerExec(key string) (string, error) {
 	cmd.Stderr = &out
 
 	if err := cmd.Run(); err != nil {
-		return "", errors.New("cmd: " + strings.Join(cmd.Args, " ") + ", error: " + err.Error() + ", output: " + out.String())
+		return NodeAddrs{}, errors.New("cmd: " + strings.Join(cmd.Args, " ") + ", error: " + err.Error() + ", output: " + out.String())
 	}
 
 	var result RegisterResult
 	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
-		return "", err
+		return NodeAddrs{}, err
 	}
 	if result.Error != "" {
-		return "", errors.New(result.Error)
+		return NodeAddrs{}, errors.New(result.Error)
 	}
+	return parseNodeAddrs(result.IPAddresses)
+}
 
-	for _, ip := range result.IPAddresses {
-		if strings.Contains(ip, ".") {
-			return ip, nil
+// parseNodeAddrs 从 headscale 返回的地址列表中取第一个 IPv4 与第一个 IPv6 地址
+func parseNodeAddrs(ips []string) (NodeAddrs, error) {
+	var addrs NodeAddrs
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil:
+			if addrs.IPv4 == "" {
+				addrs.IPv4 = ip.String()
+			}
+		default:
+			if addrs.IPv6 == "" {
+				addrs.IPv6 = ip.String()
+			}
 		}
 	}
-	return "", errors.New("no ipv4 address found")
+	if addrs.Primary() == "" {
+		return NodeAddrs{}, errors.New("no ip address found")
+	}
+	return addrs, nil
 }
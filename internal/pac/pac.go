@@ -0,0 +1,140 @@
+// Package pac 为注册用户生成浏览器使用的代理自动配置（PAC）文件
+package pac
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"tailscale-go-proxy/internal/gost"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 代理类型，对应 SOCKS5 与 HTTP 监听
+const (
+	ProxySOCKS5 = "socks5"
+	ProxyHTTP   = "http"
+)
+
+// Options 为 PAC 生成参数
+type Options struct {
+	ProxyHost     string   // 客户端访问代理使用的主机名，为空时取请求的 Host
+	SOCKS5Port    int      // SOCKS5 监听端口，<= 0 表示未启用
+	HTTPPort      int      // HTTP 监听端口，<= 0 表示未启用
+	DefaultProxy  string   // 未指定 ?proxy= 时使用的代理类型，默认 http
+	BypassDomains []string // 直连域名，"example.com" 同时匹配其子域名，可使用 * 通配
+	BypassCIDRs   []string // 直连网段
+}
+
+// Generator 根据 Options 生成 PAC 文件
+type Generator struct {
+	opts     Options
+	networks []*net.IPNet
+}
+
+// New 校验参数并创建 Generator
+func New(opts Options) (*Generator, error) {
+	if opts.DefaultProxy == "" {
+		opts.DefaultProxy = ProxyHTTP
+	}
+	if opts.DefaultProxy != ProxyHTTP && opts.DefaultProxy != ProxySOCKS5 {
+		return nil, fmt.Errorf("不支持的 PAC 代理类型: %s", opts.DefaultProxy)
+	}
+	networks, err := gost.ParseCIDRs(opts.BypassCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("PAC 直连网段无效: %w", err)
+	}
+	return &Generator{opts: opts, networks: networks}, nil
+}
+
+// Handle 处理 GET /pac/:key，返回该注册 key 的 PAC 文件；key 未注册返回 404。
+// 可通过 ?proxy=socks5|http 选择代理类型。
+// 浏览器不支持在 PAC 中携带凭据：HTTP 代理由浏览器弹出认证框（用户名、密码均为 key），
+// SOCKS5 代理需配合匿名访问策略使用。
+func (g *Generator) Handle(c *gin.Context) {
+	key := c.Param("key")
+	if key == "" || !gost.HasUser(key) {
+		c.String(404, "unknown key")
+		return
+	}
+	proxy := c.DefaultQuery("proxy", g.opts.DefaultProxy)
+	host := g.opts.ProxyHost
+	if host == "" {
+		host = c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	script, err := g.Script(proxy, host)
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	c.Data(200, "application/x-ns-proxy-autoconfig", []byte(script))
+}
+
+// Script 生成 FindProxyForURL 脚本，proxy 为代理类型，host 为代理主机名。
+func (g *Generator) Script(proxy, host string) (string, error) {
+	var directive string
+	switch proxy {
+	case ProxyHTTP:
+		if g.opts.HTTPPort <= 0 {
+			return "", fmt.Errorf("HTTP 代理未启用")
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(g.opts.HTTPPort))
+		directive = "PROXY " + addr
+	case ProxySOCKS5:
+		if g.opts.SOCKS5Port <= 0 {
+			return "", fmt.Errorf("SOCKS5 代理未启用")
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(g.opts.SOCKS5Port))
+		directive = "SOCKS5 " + addr + "; SOCKS " + addr
+	default:
+		return "", fmt.Errorf("不支持的代理类型: %s", proxy)
+	}
+
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  if (isPlainHostName(host)) return \"DIRECT\";\n")
+	for _, d := range g.opts.BypassDomains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "."))
+		if d == "" {
+			continue
+		}
+		if strings.Contains(d, "*") {
+			fmt.Fprintf(&b, "  if (shExpMatch(host, %q)) return \"DIRECT\";\n", d)
+		} else {
+			fmt.Fprintf(&b, "  if (host == %q || dnsDomainIs(host, %q)) return \"DIRECT\";\n", d, "."+d)
+		}
+	}
+	var v4, v6 []*net.IPNet
+	for _, n := range g.networks {
+		if n.IP.To4() != nil {
+			v4 = append(v4, n)
+		} else {
+			v6 = append(v6, n)
+		}
+	}
+	if len(v4) > 0 {
+		b.WriteString("  var ip = dnsResolve(host);\n")
+		for _, n := range v4 {
+			fmt.Fprintf(&b, "  if (ip && isInNet(ip, %q, %q)) return \"DIRECT\";\n", n.IP.To4(), net.IP(n.Mask).String())
+		}
+	}
+	if len(v6) > 0 {
+		// IPv6 网段需要浏览器支持 dnsResolveEx 与 isInNetEx，dnsResolveEx 返回以分号分隔的全部地址
+		b.WriteString("  if (typeof dnsResolveEx == \"function\" && typeof isInNetEx == \"function\") {\n")
+		b.WriteString("    var ip6 = dnsResolveEx(host).split(\";\");\n")
+		b.WriteString("    for (var i = 0; i < ip6.length; i++) {\n")
+		for _, n := range v6 {
+			fmt.Fprintf(&b, "      if (isInNetEx(ip6[i], %q)) return \"DIRECT\";\n", n)
+		}
+		b.WriteString("    }\n")
+		b.WriteString("  }\n")
+	}
+	fmt.Fprintf(&b, "  return %q;\n", directive)
+	b.WriteString("}\n")
+	return b.String(), nil
+}
@@ -0,0 +1,91 @@
+package pac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale-go-proxy/internal/gost"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestHandlePAC 测试 /pac/:key 的代理类型选择、直连规则与未知 key
+func TestHandlePAC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gost.AddUserToProxyMap("packey", "100.64.0.5")
+	gen, err := New(Options{
+		SOCKS5Port:    1080,
+		HTTPPort:      1089,
+		BypassDomains: []string{"example.internal", "*.corp"},
+		BypassCIDRs:   []string{"10.0.0.0/8", "fd00::/8"},
+	})
+	if err != nil {
+		t.Fatalf("创建 PAC 生成器失败: %v", err)
+	}
+	r := gin.New()
+	r.GET("/pac/:key", gen.Handle)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		contains []string
+	}{
+		{"默认 HTTP 代理", "/pac/packey", 200, []string{
+			`return "PROXY proxy.example.com:1089";`,
+			`dnsDomainIs(host, ".example.internal")`,
+			`shExpMatch(host, "*.corp")`,
+			`isInNet(ip, "10.0.0.0", "255.0.0.0")`,
+			`isInNetEx(ip6[i], "fd00::/8")`,
+		}},
+		{"SOCKS5 代理", "/pac/packey?proxy=socks5", 200, []string{`return "SOCKS5 proxy.example.com:1080; SOCKS proxy.example.com:1080";`}},
+		{"不支持的代理类型", "/pac/packey?proxy=ftp", 400, nil},
+		{"未知 key", "/pac/nosuchkey", 404, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Host = "proxy.example.com:8081"
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望状态码 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantCode == 200 && w.Header().Get("Content-Type") != "application/x-ns-proxy-autoconfig" {
+				t.Errorf("Content-Type 错误: %s", w.Header().Get("Content-Type"))
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(w.Body.String(), s) {
+					t.Errorf("PAC 中缺少 %s\n%s", s, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// TestScriptIPv6 测试 IPv6 直连网段按 dnsResolveEx 的解析结果匹配，而不是直接以主机名调用 isInNetEx
+func TestScriptIPv6(t *testing.T) {
+	gen, err := New(Options{HTTPPort: 1089, BypassCIDRs: []string{"2001:db8::/32"}})
+	if err != nil {
+		t.Fatalf("创建 PAC 生成器失败: %v", err)
+	}
+	script, err := gen.Script(ProxyHTTP, "proxy.example.com")
+	if err != nil {
+		t.Fatalf("生成 PAC 失败: %v", err)
+	}
+	want := `  if (typeof dnsResolveEx == "function" && typeof isInNetEx == "function") {
+    var ip6 = dnsResolveEx(host).split(";");
+    for (var i = 0; i < ip6.length; i++) {
+      if (isInNetEx(ip6[i], "2001:db8::/32")) return "DIRECT";
+    }
+  }
+`
+	if !strings.Contains(script, want) {
+		t.Errorf("IPv6 网段的匹配代码不符合预期:\n%s", script)
+	}
+	if strings.Contains(script, "isInNetEx(host") || strings.Contains(script, "dnsResolve(host)") {
+		t.Errorf("仅有 IPv6 网段时不应以主机名匹配或调用 dnsResolve:\n%s", script)
+	}
+}
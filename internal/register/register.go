@@ -9,7 +9,9 @@ import (
 )
 
 type RegisterRequest struct {
-	Key string `json:"key" binding:"required"`
+	Key    string            `json:"key" binding:"required"`
+	Pool   string            `json:"pool"`   // 可选，同时将节点加入该节点池
+	Labels map[string]string `json:"labels"` // 可选，节点在池中的标签（如 country、tag），供用户名参数筛选
 }
 
 type RegisterResponse struct {
@@ -17,23 +19,40 @@ type RegisterResponse struct {
 	Message string `json:"message"`
 }
 
-// handleRegisterCommon 公共注册处理逻辑
-func handleRegisterCommon(key string, db *sql.DB, c *gin.Context) {
+// handleRegisterCommon 公共注册处理逻辑，pool 非空时同时将节点以 labels 标签加入该节点池
+func handleRegisterCommon(key, pool string, labels map[string]string, db *sql.DB, c *gin.Context) {
 	// 1. 调用 headscale 注册节点，返回分配的 IP
-	ip, err := headscale.RegisterNodeByDockerExec(key)
+	addrs, err := headscale.RegisterNodeByDockerExec(key)
 	if err != nil {
 		c.JSON(500, RegisterResponse{Success: false, Message: "注册失败: " + err.Error()})
 		return
 	}
+	ip := addrs.Primary()
 
 	// 2. 将 key 和 IP 映射关系写入数据库
-	if err := headscale.SaveKeyIP(db, key, ip); err != nil {
+	if err := headscale.SaveKeyIP(db, key, ip, addrs.IPv6); err != nil {
 		c.JSON(500, RegisterResponse{Success: false, Message: "数据库保存失败: " + err.Error()})
 		return
 	}
 
 	// 3. 注册和数据库都成功后，增量写入 gost 配置并热加载，保证新注册用户立即生效
 	gost.AddUserToProxyMap(key, ip)
+	// 3.0 记录节点的 IPv6 地址，连接该节点时按地址族偏好竞速
+	if addrs.IPv6 != ip {
+		gost.SetNodeIPv6(ip, addrs.IPv6)
+	}
+	// 3.1 加入节点池
+	if pool != "" {
+		strategy, err := headscale.AddPoolMember(db, pool, ip, labels)
+		if err != nil {
+			c.JSON(500, RegisterResponse{Success: false, Message: "加入节点池失败: " + err.Error()})
+			return
+		}
+		if err := gost.AddNodeToPool(pool, strategy, ip, labels); err != nil {
+			c.JSON(500, RegisterResponse{Success: false, Message: "加入节点池失败: " + err.Error()})
+			return
+		}
+	}
 	// 4. 返回注册成功和分配的 IP
 	c.JSON(200, RegisterResponse{Success: true, Message: "注册成功，IP: " + ip})
 }
@@ -45,15 +64,15 @@ func HandleRegister(c *gin.Context, db *sql.DB) {
 		c.JSON(400, RegisterResponse{Success: false, Message: "参数错误"})
 		return
 	}
-	handleRegisterCommon(req.Key, db, c)
+	handleRegisterCommon(req.Key, req.Pool, req.Labels, db, c)
 }
 
-// HandleRegisterV2 处理新版注册请求，支持 code 注册码（GET 方法，参数从 path 获取）
+// HandleRegisterV2 处理新版注册请求，支持 code 注册码（GET 方法，参数从 path 获取），可选 ?pool= 加入节点池、?labels[country]=us 设置节点标签
 func HandleRegisterV2(c *gin.Context, db *sql.DB) {
 	code := c.Param("key")
 	if code == "" {
 		c.JSON(400, RegisterResponse{Success: false, Message: "缺少 code 参数"})
 		return
 	}
-	handleRegisterCommon(code, db, c)
+	handleRegisterCommon(code, c.Query("pool"), c.QueryMap("labels"), db, c)
 }
@@ -0,0 +1,57 @@
+package tailscale
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// 默认的嵌入式节点主机名与状态目录
+const (
+	DefaultEmbeddedHostname = "go-proxy"
+	DefaultEmbeddedStateDir = "/var/lib/tailscale-go-proxy/tsnet"
+)
+
+// ErrEmbeddedUnsupported 表示当前二进制构建时未启用 tsnet 构建标签。
+var ErrEmbeddedUnsupported = errors.New("当前二进制未包含 tsnet 支持，请以 -tags tsnet 构建")
+
+// EmbeddedConfig 为进程内 tsnet 节点的参数。
+// 同一主机运行多个实例时，各实例须使用不同的 StateDir 与 Hostname。
+type EmbeddedConfig struct {
+	AuthKey     string
+	LoginServer string // 控制服务器（如 headscale）地址，为空时使用 Tailscale 官方控制服务器
+	Hostname    string // 为空时使用 DefaultEmbeddedHostname
+	StateDir    string // 节点状态与密钥的保存目录，为空时使用 DefaultEmbeddedStateDir
+	Ephemeral   bool   // 为 true 时注册为临时节点，离线后由控制服务器自动删除
+}
+
+// Embedded 为以 tsnet 在进程内加入 tailnet 的节点，不需要 tailscaled、NET_ADMIN 与 /dev/net/tun。
+// 实现 gost.Dialer，经用户态网络栈连接 tailnet 中的节点。
+type Embedded struct {
+	dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+	close func() error
+}
+
+// StartEmbedded 启动进程内 tsnet 节点并等待其加入 tailnet，ctx 结束前未就绪时返回错误。
+func StartEmbedded(ctx context.Context, cfg EmbeddedConfig) (*Embedded, error) {
+	if cfg.AuthKey == "" {
+		return nil, errors.New("TS_AUTHKEY 环境变量未设置")
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname = DefaultEmbeddedHostname
+	}
+	if cfg.StateDir == "" {
+		cfg.StateDir = DefaultEmbeddedStateDir
+	}
+	return startEmbedded(ctx, cfg)
+}
+
+// DialContext 经 tsnet 节点连接 addr。
+func (e *Embedded) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return e.dial(ctx, network, addr)
+}
+
+// Close 使节点离开 tailnet 并释放资源。
+func (e *Embedded) Close() error {
+	return e.close()
+}
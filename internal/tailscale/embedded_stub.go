@@ -0,0 +1,9 @@
+//go:build !tsnet
+
+package tailscale
+
+import "context"
+
+func startEmbedded(context.Context, EmbeddedConfig) (*Embedded, error) {
+	return nil, ErrEmbeddedUnsupported
+}
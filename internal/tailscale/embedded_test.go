@@ -0,0 +1,14 @@
+package tailscale
+
+import (
+	"context"
+	"testing"
+)
+
+// TestStartEmbedded_InvalidAuthKey 测试未设置 auth key 时在启动节点前报错
+func TestStartEmbedded_InvalidAuthKey(t *testing.T) {
+	_, err := StartEmbedded(context.Background(), EmbeddedConfig{Hostname: "go-proxy-test", StateDir: t.TempDir()})
+	if err == nil || err.Error() != "TS_AUTHKEY 环境变量未设置" {
+		t.Errorf("期望 TS_AUTHKEY 环境变量未设置 错误，实际: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build tsnet
+
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"tailscale.com/tsnet"
+)
+
+func startEmbedded(ctx context.Context, cfg EmbeddedConfig) (*Embedded, error) {
+	if err := os.MkdirAll(cfg.StateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("创建 tsnet 状态目录失败: %w", err)
+	}
+	srv := &tsnet.Server{
+		Dir:        cfg.StateDir,
+		Hostname:   cfg.Hostname,
+		AuthKey:    cfg.AuthKey,
+		ControlURL: cfg.LoginServer,
+		Ephemeral:  cfg.Ephemeral,
+		// 后端日志量很大，只保留需要用户处理的信息（如登录地址）
+		Logf:     func(string, ...any) {},
+		UserLogf: log.Printf,
+	}
+	status, err := srv.Up(ctx)
+	if err != nil {
+		srv.Close()
+		return nil, fmt.Errorf("tsnet 节点加入 tailnet 失败: %w", err)
+	}
+	log.Printf("tsnet node %s joined tailnet, addresses: %v", cfg.Hostname, status.TailscaleIPs)
+	return &Embedded{dial: srv.Dial, close: srv.Close}, nil
+}
@@ -0,0 +1,30 @@
+//go:build tsnet
+
+package tailscale
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestStartEmbedded_ControlUnreachable 测试控制服务器不可达时在 ctx 结束后返回错误并释放节点
+func TestStartEmbedded_ControlUnreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	e, err := StartEmbedded(ctx, EmbeddedConfig{
+		AuthKey:     "tskey-test",
+		LoginServer: "http://127.0.0.1:1",
+		Hostname:    "go-proxy-test",
+		StateDir:    t.TempDir(),
+		Ephemeral:   true,
+	})
+	if err == nil {
+		e.Close()
+		t.Fatal("期望控制服务器不可达时返回错误")
+	}
+	if errors.Is(err, ErrEmbeddedUnsupported) {
+		t.Fatalf("以 tsnet 标签构建时不应返回 ErrEmbeddedUnsupported: %v", err)
+	}
+}
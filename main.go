@@ -1,20 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
+	"net/http"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"tailscale-go-proxy/internal/api"
 	"tailscale-go-proxy/internal/config"
 	"tailscale-go-proxy/internal/gost"
+	"tailscale-go-proxy/internal/pac"
 	"tailscale-go-proxy/internal/service"
 	"tailscale-go-proxy/internal/tailscale"
-)
-
-// 代理端口常量，需与 main.go 启动端口保持一致
-const (
-	SOCKS5ProxyPort = 1080 // SOCKS5 代理端口
-	HTTPProxyPort   = 1089 // HTTP 代理端口
+	"time"
 )
 
 func main() {
@@ -30,42 +33,270 @@ func main() {
 
 	log.Printf("[DEBUG] 配置内容: %+v", cfg)
 
-	// 2. 启动 tailscaled 并 up
-	if err := tailscale.EnsureReady(tsAuthKey, cfg.LoginServer); err != nil {
-		log.Fatalf("Tailscale 启动失败: %v", err)
+	// 2. 接入 tailnet：默认启动 tailscaled 并 up；tsnet 模式在进程内加入 tailnet，节点连接经用户态网络栈
+	switch cfg.Tailscale.Mode {
+	case "", config.TailscaleKernel:
+		if err := tailscale.EnsureReady(tsAuthKey, cfg.LoginServer); err != nil {
+			log.Fatalf("Tailscale 启动失败: %v", err)
+		}
+	case config.TailscaleTsnet:
+		upCtx, cancel := context.WithTimeout(context.Background(), embeddedUpTimeout)
+		node, err := tailscale.StartEmbedded(upCtx, tailscale.EmbeddedConfig{
+			AuthKey:     tsAuthKey,
+			LoginServer: cfg.LoginServer,
+			Hostname:    cfg.Tailscale.Hostname,
+			StateDir:    cfg.Tailscale.StateDir,
+			Ephemeral:   cfg.Tailscale.Ephemeral,
+		})
+		cancel()
+		if err != nil {
+			log.Fatalf("Tailscale 启动失败: %v", err)
+		}
+		defer node.Close()
+		gost.SetTailnetDialer(node)
+	default:
+		log.Fatalf("tailscale.mode 只支持 kernel 或 tsnet，实际: %s", cfg.Tailscale.Mode)
 	}
 
 	// 3. 初始化数据库
 	db := service.MustInitDB(cfg)
 	defer db.Close()
 
-	// 4. 从数据库导出并热加载 gost 用户转发表
+	// 4. 从数据库导出并热加载 gost 用户转发表与路由规则，配置文件中的静态路由优先；路由或规则无效的 key 跳过并告警
+	if err := gost.SetStaticRoutes(routesFromConfig(cfg.Routes)); err != nil {
+		log.Fatalf("routes 配置错误: %v", err)
+	}
+	if err := gost.SetDNS(gost.DNSConfig(cfg.DNS)); err != nil {
+		log.Fatalf("dns 配置错误: %v", err)
+	}
+	if err := gost.SetDualStack(gost.DualStackConfig(cfg.DualStack)); err != nil {
+		log.Fatalf("dual_stack 配置错误: %v", err)
+	}
+	if cfg.GeoIPDB != "" {
+		geoip, err := gost.OpenGeoIP(cfg.GeoIPDB)
+		if err != nil {
+			log.Fatalf("GeoIP 数据库加载失败: %v", err)
+		}
+		gost.SetGeoIP(geoip)
+	}
+	if err := gost.SetRules(rulesFromConfig(cfg.Rules), routesFromConfig(cfg.NamedRoutes)); err != nil {
+		log.Fatalf("rules 配置错误: %v", err)
+	}
 	if err := gost.RefreshUserProxyMapFromDB(db); err != nil {
-		log.Fatalf("gost 用户转发表导出/加载失败: %v", err)
+		var routeErr *gost.RouteError
+		if !errors.As(err, &routeErr) {
+			log.Fatalf("gost 用户转发表导出/加载失败: %v", err)
+		}
+		log.Printf("[WARN] 部分路由无效，已跳过: %v", err)
 	}
 	log.Printf("[INFO] gost 用户转发表已加载，用户数: %d", len(gost.UserProxyMap))
 
 	if len(gost.UserProxyMap) < 1000 {
-		imported, _ := json.MarshalIndent(gost.UserProxyMap, "", "  ")
+		// 下游节点的密码不写入日志
+		redacted := make(map[string]string, len(gost.UserProxyMap))
+		for k, route := range gost.UserProxyMap {
+			redacted[k] = gost.RedactRoute(route)
+		}
+		imported, _ := json.MarshalIndent(redacted, "", "  ")
 		log.Printf("[DEBUG] UserProxyMap: %s", string(imported))
 	}
 
+	// 收到 SIGINT/SIGTERM 后进入优雅关闭流程
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var servers []proxyServer
+	if cfg.DialTimeout != 0 {
+		gost.DialTimeout = max(cfg.DialTimeout, 0)
+	}
+	if cfg.StickyTTL > 0 {
+		gost.StickyTTL = cfg.StickyTTL
+	}
+	opts := proxyOptions(cfg)
+	anonymous, err := anonymousPolicy(cfg.Anonymous)
+	if err != nil {
+		log.Fatalf("匿名访问策略配置错误: %v", err)
+	}
+	opts = append(opts, gost.WithAnonymousPolicy(anonymous))
+	trusted, err := gost.ParseCIDRs(cfg.ProxyProtocolTrusted)
+	if err != nil {
+		log.Fatalf("proxy_protocol_trusted 配置错误: %v", err)
+	}
+	if v := cfg.ProxyProtocolDownstream; v != 0 && v != gost.ProxyProtocolV1 && v != gost.ProxyProtocolV2 {
+		log.Fatalf("proxy_protocol_downstream 只支持 1 或 2，实际: %d", v)
+	}
+	opts = append(opts, gost.WithProxyProtocol(trusted), gost.WithDownstreamProxyHeader(cfg.ProxyProtocolDownstream))
+
 	// 5. 启动 SOCKS5 代理
+	if port := proxyPort(cfg.SOCKS5ProxyPort, gost.SOCKS5ProxyPort); port > 0 {
+		servers = append(servers, startProxy(ctx, "SOCKS5 代理", port, gost.NewSOCKS5Server(":"+strconv.Itoa(port), opts...)))
+	}
+
+	// 6. 启动 HTTP 代理
+	if port := proxyPort(cfg.HTTPProxyPort, gost.HTTPProxyPort); port > 0 {
+		servers = append(servers, startProxy(ctx, "HTTP 代理", port, gost.NewHTTPProxyServer(":"+strconv.Itoa(port), opts...)))
+	}
+
+	// 6.1 启动单端口混合协议代理（可选）
+	if cfg.MixedProxyPort > 0 {
+		servers = append(servers, startProxy(ctx, "混合协议代理", cfg.MixedProxyPort, gost.NewMixedProxyServer(":"+strconv.Itoa(cfg.MixedProxyPort), opts...)))
+	}
+
+	// 6.2 启动 TLS 加密的 HTTP 代理（可选）
+	if cfg.HTTPSProxyPort > 0 {
+		tlsConfig, err := gost.NewServerTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("HTTPS 代理 TLS 配置失败: %v", err)
+		}
+		httpsOpts := append([]gost.Option{gost.WithTLSConfig(tlsConfig)}, opts...)
+		servers = append(servers, startProxy(ctx, "HTTPS 代理", cfg.HTTPSProxyPort, gost.NewHTTPProxyServer(":"+strconv.Itoa(cfg.HTTPSProxyPort), httpsOpts...)))
+	}
+
+	// 7. 启动 gin 路由
+	pacGen, err := pac.New(pac.Options{
+		ProxyHost:     cfg.PAC.ProxyHost,
+		SOCKS5Port:    proxyPort(cfg.SOCKS5ProxyPort, gost.SOCKS5ProxyPort),
+		HTTPPort:      proxyPort(cfg.HTTPProxyPort, gost.HTTPProxyPort),
+		DefaultProxy:  cfg.PAC.DefaultProxy,
+		BypassDomains: cfg.PAC.BypassDomains,
+		BypassCIDRs:   cfg.PAC.BypassCIDRs,
+	})
+	if err != nil {
+		log.Fatalf("PAC 配置错误: %v", err)
+	}
+	r := api.NewRouter(db, pacGen)
+	apiServer := &http.Server{Addr: ":" + strconv.Itoa(cfg.ManageAPIPort), Handler: r}
 	go func() {
-		if err := gost.NewSOCKS5Server(":" + strconv.Itoa(SOCKS5ProxyPort)).Start(); err != nil {
-			log.Fatalf("SOCKS5 代理启动失败: %v", err)
+		log.Printf("管理 API 启动于 :%d", cfg.ManageAPIPort)
+		if err := apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("管理 API 启动失败: %v", err)
 		}
 	}()
 
-	// 6. 启动 HTTP 代理
+	// 8. 等待退出信号：停止接收新连接，在期限内排空隧道，超时后强制关闭
+	<-ctx.Done()
+	stop()
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	log.Printf("收到退出信号，开始优雅关闭，排空期限 %s", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv proxyServer) {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("代理关闭: %v", err)
+			}
+		}(srv)
+	}
+	apiServer.Shutdown(shutdownCtx)
+	wg.Wait()
+	log.Printf("已退出")
+}
+
+// defaultShutdownTimeout 为未配置 shutdown_timeout 时的默认排空期限。
+const defaultShutdownTimeout = 30 * time.Second
+
+// embeddedUpTimeout 为 tsnet 节点加入 tailnet 的最长等待时间。
+const embeddedUpTimeout = 2 * time.Minute
+
+// proxyServer 为各代理服务共有的启动与优雅关闭接口。
+type proxyServer interface {
+	Serve(ctx context.Context, listener net.Listener) error
+	Shutdown(ctx context.Context) error
+}
+
+// startProxy 监听端口并在后台启动代理服务，监听失败直接退出。
+func startProxy(ctx context.Context, name string, port int, srv proxyServer) proxyServer {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		log.Fatalf("%s启动失败: %v", name, err)
+	}
+	log.Printf("%s已启动，监听地址: :%d", name, port)
 	go func() {
-		if err := gost.NewHTTPProxyServer(":" + strconv.Itoa(HTTPProxyPort)).Start(); err != nil {
-			log.Fatalf("HTTP 代理启动失败: %v", err)
+		if err := srv.Serve(ctx, listener); err != nil && err != gost.ErrServerClosed {
+			log.Fatalf("%s运行失败: %v", name, err)
 		}
 	}()
+	return srv
+}
 
-	// 7. 启动 gin 路由
-	r := api.NewRouter(db)
-	log.Printf("管理 API 启动于 :%d", cfg.ManageAPIPort)
-	r.Run(":" + strconv.Itoa(cfg.ManageAPIPort))
+// proxyOptions 根据配置生成代理会话超时参数：未配置（0）的项保留默认值，负值表示不限制。
+func proxyOptions(cfg *config.Config) []gost.Option {
+	var opts []gost.Option
+	if cfg.HandshakeTimeout != 0 {
+		opts = append(opts, gost.WithHandshakeTimeout(max(cfg.HandshakeTimeout, 0)))
+	}
+	if cfg.IdleTimeout != 0 {
+		opts = append(opts, gost.WithIdleTimeout(max(cfg.IdleTimeout, 0)))
+	}
+	return opts
+}
+
+// anonymousPolicy 根据配置创建匿名访问策略。
+func anonymousPolicy(cfg config.AnonymousConfig) (*gost.AnonymousPolicy, error) {
+	rules := make([]gost.AnonymousRule, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		rules = append(rules, gost.AnonymousRule{CIDR: r.CIDR, Route: r.Route})
+	}
+	return gost.NewAnonymousPolicy(cfg.DefaultRoute, rules)
+}
+
+// routesFromConfig 将配置文件中的路由转换为 gost.Route。
+func routesFromConfig(routes map[string]config.RouteConfig) map[string]gost.Route {
+	out := make(map[string]gost.Route, len(routes))
+	for name, r := range routes {
+		out[name] = routeFromConfig(r)
+	}
+	return out
+}
+
+func routeFromConfig(r config.RouteConfig) gost.Route {
+	route := gost.Route{
+		Direct:   r.Direct,
+		Node:     r.Node,
+		Strategy: r.Strategy,
+		Labels:   r.Labels,
+		Timeout:  gost.Duration(r.Timeout),
+		Cooldown: gost.Duration(r.Cooldown),
+	}
+	for _, h := range r.Hops {
+		route.Hops = append(route.Hops, gost.Hop{
+			Type:     h.Type,
+			Addr:     h.Addr,
+			Username: h.Username,
+			Password: h.Password,
+			Cipher:   h.Cipher,
+			Timeout:  gost.Duration(h.Timeout),
+			TLS:      (*gost.HopTLS)(h.TLS),
+			SSH:      (*gost.HopSSHOptions)(h.SSH),
+		})
+	}
+	for _, c := range r.Failover {
+		route.Failover = append(route.Failover, routeFromConfig(c))
+	}
+	for _, m := range r.Pool {
+		route.Pool = append(route.Pool, routeFromConfig(m))
+	}
+	return route
+}
+
+// rulesFromConfig 将配置文件中的规则转换为 gost.Rule。
+func rulesFromConfig(rules []config.RuleConfig) []gost.Rule {
+	out := make([]gost.Rule, 0, len(rules))
+	for _, r := range rules {
+		out = append(out, gost.Rule(r))
+	}
+	return out
+}
+
+// proxyPort 返回代理监听端口：配置为 0 时使用默认端口，负数表示关闭。
+func proxyPort(configured, def int) int {
+	if configured == 0 {
+		return def
+	}
+	return configured
 }